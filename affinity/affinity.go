@@ -0,0 +1,193 @@
+// Package affinity pins threads and processes to CPUs and describes how
+// CPUs share cores and sockets, to control where the host and a plugin run.
+package affinity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned on systems where affinity cannot be set.
+var ErrUnsupported = errors.New("CPU affinity is not supported on " + runtime.GOOS)
+
+// LockThread locks the calling goroutine to its OS thread and pins the
+// thread to cpus. The returned function restores the thread's previous
+// affinity and unlocks it.
+func LockThread(cpus []int) (unlock func(), err error) {
+	runtime.LockOSThread()
+	previous, err := get(0)
+	if err == nil {
+		err = set(0, cpus)
+	}
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	return func() {
+		set(0, previous)
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+// SetProcess pins every thread of the process pid to cpus. Threads the
+// process starts afterwards inherit the affinity of the thread that starts
+// them, so the whole process stays on cpus.
+func SetProcess(pid int, cpus []int) error {
+	done := map[int]bool{}
+	for {
+		tids, err := threads(pid)
+		if err != nil {
+			return err
+		}
+		pinned := false
+		for _, tid := range tids {
+			if done[tid] {
+				continue
+			}
+			if err := set(tid, cpus); err != nil {
+				return fmt.Errorf("thread %d: %w", tid, err)
+			}
+			done[tid] = true
+			pinned = true
+		}
+		// Go again in case a thread was started by one not yet pinned
+		if !pinned {
+			return nil
+		}
+	}
+}
+
+// Allowed returns the CPUs the calling thread may run on.
+func Allowed() ([]int, error) {
+	return get(0)
+}
+
+// threads returns the thread IDs of the process pid.
+func threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	var tids []int
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// ParseList parses a CPU list such as "0-3,8,10-11", as used by sysfs and
+// taskset.
+func ParseList(s string) ([]int, error) {
+	var cpus []int
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU list %q", s)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("bad CPU list %q", s)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// CPU is where a logical CPU sits in the machine.
+type CPU struct {
+	ID     int
+	Core   int
+	Socket int
+}
+
+// Topology returns the CPUs the calling thread may run on and where they
+// sit, read from sysfs.
+func Topology() ([]CPU, error) {
+	allowed, err := Allowed()
+	if err != nil {
+		return nil, err
+	}
+	var cpus []CPU
+	for _, id := range allowed {
+		dir := fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology", id)
+		core, err := readInt(filepath.Join(dir, "core_id"))
+		if err != nil {
+			return nil, err
+		}
+		socket, err := readInt(filepath.Join(dir, "physical_package_id"))
+		if err != nil {
+			return nil, err
+		}
+		cpus = append(cpus, CPU{ID: id, Core: core, Socket: socket})
+	}
+	return cpus, nil
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Placement puts the host and the plugin on particular CPUs.
+type Placement struct {
+	Name   string
+	Host   int
+	Plugin int
+}
+
+// Placement names, from closest to furthest apart.
+const (
+	// SameCPU runs the host and plugin on the same logical CPU.
+	SameCPU = "same-cpu"
+	// Sibling runs them on SMT siblings of one core.
+	Sibling = "sibling"
+	// SameSocket runs them on different cores of one socket.
+	SameSocket = "same-socket"
+	// CrossSocket runs them on different sockets.
+	CrossSocket = "cross-socket"
+)
+
+// Placements are the placement names in order.
+var Placements = []string{SameCPU, Sibling, SameSocket, CrossSocket}
+
+// Place returns the CPUs for placement name among cpus, with the host on the
+// first CPU that has a partner. It reports false if the machine has no such
+// pair of CPUs.
+func Place(cpus []CPU, name string) (Placement, bool) {
+	related := map[string]func(a, b CPU) bool{
+		SameCPU:     func(a, b CPU) bool { return a.ID == b.ID },
+		Sibling:     func(a, b CPU) bool { return a.ID != b.ID && a.Socket == b.Socket && a.Core == b.Core },
+		SameSocket:  func(a, b CPU) bool { return a.Socket == b.Socket && a.Core != b.Core },
+		CrossSocket: func(a, b CPU) bool { return a.Socket != b.Socket },
+	}[name]
+	if related == nil {
+		return Placement{}, false
+	}
+	for _, host := range cpus {
+		i := slices.IndexFunc(cpus, func(plugin CPU) bool { return related(host, plugin) })
+		if i >= 0 {
+			return Placement{Name: name, Host: host.ID, Plugin: cpus[i].ID}, true
+		}
+	}
+	return Placement{}, false
+}
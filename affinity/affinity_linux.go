@@ -0,0 +1,44 @@
+package affinity
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// maxCPUs is the number of CPUs a mask covers.
+const maxCPUs = 1024
+
+type mask [maxCPUs / 64]uint64
+
+// set sets the affinity of thread tid, or of the calling thread if tid is 0.
+func set(tid int, cpus []int) error {
+	var m mask
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maxCPUs {
+			return syscall.EINVAL
+		}
+		m[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// get returns the affinity of thread tid, or of the calling thread if tid
+// is 0.
+func get(tid int) ([]int, error) {
+	var m mask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, uintptr(tid), unsafe.Sizeof(m), uintptr(unsafe.Pointer(&m)))
+	if errno != 0 {
+		return nil, errno
+	}
+	var cpus []int
+	for cpu := range maxCPUs {
+		if m[cpu/64]&(1<<(cpu%64)) != 0 {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package affinity
+
+func set(tid int, cpus []int) error {
+	return ErrUnsupported
+}
+
+func get(tid int) ([]int, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,92 @@
+package affinity
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	got, err := ParseList("0-2,5,8-9\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 5, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"a", "3-1", "1-x"} {
+		if _, err := ParseList(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	// Two sockets of two cores with two threads each
+	var cpus []CPU
+	for id := range 8 {
+		cpus = append(cpus, CPU{ID: id, Core: id / 2 % 2, Socket: id / 4})
+	}
+	for _, tt := range []struct {
+		name         string
+		host, plugin int
+	}{
+		{SameCPU, 0, 0},
+		{Sibling, 0, 1},
+		{SameSocket, 0, 2},
+		{CrossSocket, 0, 4},
+	} {
+		got, ok := Place(cpus, tt.name)
+		if !ok || got.Host != tt.host || got.Plugin != tt.plugin {
+			t.Errorf("%s: got %+v, %v", tt.name, got, ok)
+		}
+	}
+
+	if _, ok := Place(cpus[:1], Sibling); ok {
+		t.Error("Placed siblings on a single CPU")
+	}
+}
+
+func TestLockThread(t *testing.T) {
+	allowed, err := Allowed()
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := LockThread(allowed[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := Allowed(); !reflect.DeepEqual(got, allowed[:1]) {
+		t.Errorf("Pinned thread may run on %v, want %v", got, allowed[:1])
+	}
+	unlock()
+}
+
+func TestSetProcess(t *testing.T) {
+	allowed, err := Allowed()
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Cannot start sleep: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	if err := SetProcess(cmd.Process.Pid, allowed[len(allowed)-1:]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := get(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, allowed[len(allowed)-1:]) {
+		t.Errorf("Process may run on %v, want %v", got, allowed[len(allowed)-1:])
+	}
+}
@@ -0,0 +1,432 @@
+// Package bench measures calls from the host into plugins over each
+// transport, outside of go test.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/goipcbench/affinity"
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/procstat"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// startTimeout bounds how long a plugin may take to start up.
+const startTimeout = 5 * time.Second
+
+// Transports are the names of the transports in the order they are usually
+// reported.
+var Transports = host.Transports
+
+// echo is the method every case calls.
+const echo = "echo"
+
+// maxPayload maps each transport to the largest payload it carries in a
+// call to echo, as the limit a transport sets is on the method and payload
+// together.
+var maxPayload = map[string]int{
+	"stdio": protocol.MaxStreamPayload - len(echo),
+	"tcp":   protocol.MaxStreamPayload - len(echo),
+	"unix":  protocol.MaxStreamPayload - len(echo),
+	"mmap":  protocol.MaxMmapPayload - len(echo),
+}
+
+// Case is one combination of settings to measure.
+type Case struct {
+	Transport string
+	// Wait is how the host waits on shared memory. It only applies to the
+	// mmap transport.
+	Wait protocol.WaitStrategy
+	// Size is the payload size of each request and response.
+	Size int
+	// Concurrency is the number of plugin processes called at once, each from
+	// its own goroutine, since a plugin serves one request at a time.
+	Concurrency int
+	// Rate is the total calls per second to make across all plugins, each
+	// at its scheduled time whether or not earlier calls have returned.
+	// Zero calls each plugin again as soon as it replies.
+	Rate float64
+}
+
+func (c Case) String() string {
+	name := c.Transport
+	if c.Transport == "mmap" {
+		name += "/" + c.Wait.String()
+	}
+	name = fmt.Sprintf("%s/size=%d/conc=%d", name, c.Size, c.Concurrency)
+	if c.Rate > 0 {
+		name += fmt.Sprintf("/rate=%g", c.Rate)
+	}
+	return name
+}
+
+// Validate returns an error if c cannot be run.
+func (c Case) Validate() error {
+	if !slices.Contains(host.Transports, c.Transport) {
+		return fmt.Errorf("unknown transport %q", c.Transport)
+	}
+	if c.Size < 0 || c.Size > maxPayload[c.Transport] {
+		return fmt.Errorf("%s: payload size %d is outside 0 to %d", c.Transport, c.Size, maxPayload[c.Transport])
+	}
+	if c.Concurrency < 1 {
+		return fmt.Errorf("concurrency %d is less than 1", c.Concurrency)
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("rate %g is negative", c.Rate)
+	}
+	return nil
+}
+
+// Options configures how cases are run.
+type Options struct {
+	// Plugin returns the binary that runs the plugin for transport and the
+	// arguments that select it.
+	Plugin func(transport string) (path string, args []string)
+
+	// Warmup is how long each plugin is called for before measuring, so the
+	// measurement excludes page faults on fresh buffers and other start up
+	// costs.
+	Warmup time.Duration
+
+	// Duration is how long each case makes calls for. If Calls is set each
+	// plugin is instead called that many times.
+	Duration time.Duration
+	Calls    int
+
+	// Stderr receives the plugins' standard error. If nil it is discarded.
+	Stderr io.Writer
+
+	// ProfileDir makes the plugins write profiles and traces into this
+	// directory, as host.LaunchOptions.ProfileDir.
+	ProfileDir string
+
+	// HostCPUs pins the threads making calls to these CPUs and PluginCPUs
+	// pins the plugin processes. Empty means no pinning.
+	HostCPUs   []int
+	PluginCPUs []int
+}
+
+// Result is the measurement of one case.
+type Result struct {
+	Case
+	// Ops is the number of calls made by all workers.
+	Ops int
+	// Elapsed is the wall time the workers spent calling.
+	Elapsed time.Duration
+	// Latency summarizes the time each call took. With a Rate it is from
+	// when the call was scheduled rather than sent, so the time a call
+	// waited behind slow earlier ones counts.
+	Latency Latency
+	// Dropped is the number of calls scheduled by a Rate that had not been
+	// sent when the measurement ended.
+	Dropped int
+
+	// HostCPU is the user and system CPU time the host process used while
+	// calling.
+	HostCPU time.Duration
+	// PluginCPU is the user and system CPU time the plugin processes used
+	// while being called. Where the usage of another process cannot be read
+	// it covers their whole lives, including starting up and shutting down.
+	PluginCPU time.Duration
+	// CtxSwitches and Syscalls count the context switches and read and write
+	// system calls of the host and plugins together while calling.
+	CtxSwitches int64
+	Syscalls    int64
+
+	// Allocs and AllocBytes count the host's heap allocations while calling.
+	Allocs     uint64
+	AllocBytes uint64
+
+	// HostCPUs and PluginCPUs are the CPUs the host and plugins were pinned
+	// to, if any.
+	HostCPUs   []int
+	PluginCPUs []int
+}
+
+// OpsPerSec is the number of calls completed per second by all workers
+// together.
+func (r *Result) OpsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// Latency summarizes call durations.
+type Latency struct {
+	Mean, P50, P90, P99, Max time.Duration
+}
+
+// summarize returns the summary of samples, which it sorts.
+func summarize(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	slices.Sort(samples)
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	return Latency{
+		Mean: total / time.Duration(len(samples)),
+		P50:  percentile(samples, 50),
+		P90:  percentile(samples, 90),
+		P99:  percentile(samples, 99),
+		Max:  samples[len(samples)-1],
+	}
+}
+
+// percentile returns the p-th percentile of sorted samples by the nearest
+// rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// Run measures c. It starts c.Concurrency plugins, calls each with an echo of
+// c.Size bytes from its own goroutine for opts.Warmup and then for
+// opts.Duration or opts.Calls times while measuring, and shuts them down.
+// Each Run is an independent trial with new plugins.
+func Run(ctx context.Context, c Case, opts Options) (*Result, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	plugins := make([]*host.Plugin, 0, c.Concurrency)
+	defer func() {
+		for _, p := range plugins {
+			p.Close()
+		}
+	}()
+	for range c.Concurrency {
+		p, err := start(ctx, c, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", c, err)
+		}
+		plugins = append(plugins, p)
+	}
+
+	payload := bytes.Repeat([]byte{'x'}, c.Size)
+	samples := make([][]time.Duration, len(plugins))
+	dropped := make([]int, len(plugins))
+	errs := make([]error, len(plugins))
+	// Each worker warms up, then waits for the others before measuring, so
+	// that the usage read around the measurement excludes the warm-up
+	var warmed, wg sync.WaitGroup
+	measure := make(chan struct{})
+	warmupEnd := time.Now().Add(opts.Warmup)
+	var begin, end time.Time
+	for i, p := range plugins {
+		warmed.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if len(opts.HostCPUs) > 0 {
+				unlock, err := affinity.LockThread(opts.HostCPUs)
+				if err != nil {
+					errs[i] = fmt.Errorf("pin host thread: %w", err)
+					warmed.Done()
+					return
+				}
+				defer unlock()
+			}
+			if opts.Warmup > 0 {
+				if _, errs[i] = call(ctx, p, payload, warmupEnd, 0); errs[i] != nil {
+					errs[i] = fmt.Errorf("warm up: %w", errs[i])
+				}
+			}
+			warmed.Done()
+			<-measure
+			switch {
+			case errs[i] != nil:
+			case c.Rate > 0:
+				// Stagger the plugins' schedules so calls are evenly spread
+				interval := time.Duration(float64(time.Second) * float64(c.Concurrency) / c.Rate)
+				first := begin.Add(time.Duration(i) * interval / time.Duration(c.Concurrency))
+				samples[i], dropped[i], errs[i] = callAt(ctx, p, payload, first, interval, end, opts.Calls)
+			default:
+				samples[i], errs[i] = call(ctx, p, payload, end, opts.Calls)
+			}
+		}()
+	}
+	warmed.Wait()
+
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	hostBefore, err := procstat.Self()
+	if err != nil {
+		close(measure)
+		wg.Wait()
+		return nil, err
+	}
+	pluginsBefore, pluginErr := pluginUsage(plugins)
+	begin = time.Now()
+	if opts.Calls <= 0 {
+		end = begin.Add(opts.Duration)
+	}
+	close(measure)
+	wg.Wait()
+	elapsed := time.Since(begin)
+	hostAfter, err := procstat.Self()
+	if err != nil {
+		return nil, err
+	}
+	pluginsAfter, _ := pluginUsage(plugins)
+	runtime.ReadMemStats(&memAfter)
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("%v: %w", c, err)
+	}
+
+	for _, p := range plugins {
+		if err := p.Close(); err != nil {
+			return nil, fmt.Errorf("%v: %w", c, err)
+		}
+	}
+	host := hostAfter.Sub(hostBefore)
+	plugin := pluginsAfter.Sub(pluginsBefore)
+	if pluginErr != nil {
+		plugin = procstat.Usage{}
+		for _, p := range plugins {
+			plugin.CPU += p.Cmd.ProcessState.UserTime() + p.Cmd.ProcessState.SystemTime()
+		}
+	}
+
+	all := slices.Concat(samples...)
+	return &Result{
+		Case:        c,
+		Ops:         len(all),
+		Elapsed:     elapsed,
+		Latency:     summarize(all),
+		Dropped:     sum(dropped),
+		HostCPU:     host.CPU,
+		PluginCPU:   plugin.CPU,
+		CtxSwitches: host.CtxSwitches + plugin.CtxSwitches,
+		Syscalls:    host.Syscalls + plugin.Syscalls,
+		Allocs:      memAfter.Mallocs - memBefore.Mallocs,
+		AllocBytes:  memAfter.TotalAlloc - memBefore.TotalAlloc,
+		HostCPUs:    opts.HostCPUs,
+		PluginCPUs:  opts.PluginCPUs,
+	}, nil
+}
+
+// sum returns the sum of ns.
+func sum(ns []int) int {
+	total := 0
+	for _, n := range ns {
+		total += n
+	}
+	return total
+}
+
+// pluginUsage returns the combined usage of plugins.
+func pluginUsage(plugins []*host.Plugin) (procstat.Usage, error) {
+	var total procstat.Usage
+	for _, p := range plugins {
+		u, err := procstat.Process(p.Cmd.Process.Pid)
+		if err != nil {
+			return procstat.Usage{}, err
+		}
+		total = total.Add(u)
+	}
+	return total, nil
+}
+
+// start starts a plugin for c.
+func start(ctx context.Context, c Case, opts Options) (*host.Plugin, error) {
+	path, args := opts.Plugin(c.Transport)
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	return host.Launch(ctx, path, host.LaunchOptions{
+		Transport:  c.Transport,
+		Args:       args,
+		Stderr:     opts.Stderr,
+		Wait:       c.Wait,
+		CPUs:       opts.PluginCPUs,
+		ProfileDir: opts.ProfileDir,
+	})
+}
+
+// call echoes payload through p until end, or n times if n is positive, and
+// returns how long each call took.
+func call(ctx context.Context, p *host.Plugin, payload []byte, end time.Time, n int) ([]time.Duration, error) {
+	samples := make([]time.Duration, 0, n)
+	for {
+		start := time.Now()
+		if n > 0 && len(samples) == n || n <= 0 && !start.Before(end) {
+			return samples, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return samples, err
+		}
+		response, err := p.Call(context.Background(), echo, payload)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, time.Since(start))
+		if len(response) != len(payload) {
+			return samples, fmt.Errorf("echo returned %d bytes, want %d", len(response), len(payload))
+		}
+	}
+}
+
+// callAt echoes payload through p at first and every interval after it
+// until end, or n times if n is positive, and returns how long each call
+// took from when it was scheduled and how many scheduled calls were not
+// sent by end. A call scheduled while an earlier one is outstanding is sent
+// as soon as that returns.
+func callAt(ctx context.Context, p *host.Plugin, payload []byte, first time.Time, interval time.Duration, end time.Time, n int) ([]time.Duration, int, error) {
+	samples := make([]time.Duration, 0, n)
+	var pace pacer
+	for k := 0; ; k++ {
+		scheduled := first.Add(time.Duration(k) * interval)
+		if n > 0 && k == n || n <= 0 && !scheduled.Before(end) {
+			return samples, 0, nil
+		}
+		now := time.Now()
+		if n <= 0 && !now.Before(end) {
+			dropped := (end.Sub(scheduled) + interval - 1) / interval
+			return samples, int(dropped), nil
+		}
+		pace.wait(scheduled)
+		if err := ctx.Err(); err != nil {
+			return samples, 0, err
+		}
+		response, err := p.Call(context.Background(), echo, payload)
+		if err != nil {
+			return samples, 0, err
+		}
+		samples = append(samples, time.Since(scheduled))
+		if len(response) != len(payload) {
+			return samples, 0, fmt.Errorf("echo returned %d bytes, want %d", len(response), len(payload))
+		}
+	}
+}
+
+// pacer waits until scheduled times more precisely than time.Sleep, which
+// may wake up late by as much as a millisecond. It sleeps for all but the
+// lateness it has seen and yields in a loop for the rest.
+type pacer struct {
+	// late is the most a sleep has woken up late, decaying slowly so one
+	// outlier does not make it spin for good.
+	late time.Duration
+}
+
+// wait returns at t or soon after.
+func (p *pacer) wait(t time.Time) {
+	if sleep := time.Until(t) - p.late; sleep > 0 {
+		wake := time.Now().Add(sleep)
+		time.Sleep(sleep)
+		p.late = max(time.Since(wake), p.late-p.late/16)
+	}
+	for time.Now().Before(t) {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,177 @@
+package bench
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Microsecond)
+	}
+	got := summarize(samples)
+	want := Latency{
+		Mean: 50500 * time.Nanosecond,
+		P50:  50 * time.Microsecond,
+		P90:  90 * time.Microsecond,
+		P99:  99 * time.Microsecond,
+		Max:  100 * time.Microsecond,
+	}
+	if got != want {
+		t.Errorf("summarize: got %+v, want %+v", got, want)
+	}
+
+	if got := summarize(nil); got != (Latency{}) {
+		t.Errorf("summarize of no samples: got %+v", got)
+	}
+}
+
+func TestCaseValidate(t *testing.T) {
+	for _, c := range []Case{
+		{Transport: "pigeon", Concurrency: 1},
+		{Transport: "mmap", Size: 1 << 20, Concurrency: 1},
+		{Transport: "mmap", Size: protocol.MaxMmapPayload, Concurrency: 1},
+		{Transport: "tcp", Size: -1, Concurrency: 1},
+		{Transport: "tcp", Concurrency: 0},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%v: expected error", c)
+		}
+	}
+	for _, c := range []Case{
+		{Transport: "unix", Size: 4096, Concurrency: 8},
+		{Transport: "mmap", Size: protocol.MaxMmapPayload - len("echo"), Concurrency: 1},
+	} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%v: unexpected error: %v", c, err)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	results := []*Result{
+		{Case: Case{Transport: "tcp", Concurrency: 1}, Ops: 10, Elapsed: time.Second, Latency: Latency{Mean: 20 * time.Microsecond}},
+		{Case: Case{Transport: "unix", Concurrency: 1}, Ops: 20, Elapsed: time.Second, Latency: Latency{Mean: 10 * time.Microsecond}},
+	}
+	var buf strings.Builder
+	if err := WriteTable(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header and 2 rows, got:\n%s", buf.String())
+	}
+	if !strings.HasSuffix(lines[1], "2.00x") || !strings.HasSuffix(lines[2], "1.00x") {
+		t.Errorf("Unexpected relative means:\n%s", buf.String())
+	}
+}
+
+func TestSummarizeTrials(t *testing.T) {
+	tcp := Case{Transport: "tcp", Concurrency: 1}
+	unix := Case{Transport: "unix", Concurrency: 1}
+	var results []*Result
+	for _, mean := range []time.Duration{10, 20, 30} {
+		results = append(results,
+			&Result{Case: unix, Ops: 100, Elapsed: time.Second, Latency: Latency{Mean: mean}},
+			&Result{Case: tcp, Ops: 100, Elapsed: time.Second, Latency: Latency{Mean: 2 * mean}})
+	}
+
+	summaries := Summarize(results, 0.95)
+	if len(summaries) != 2 || summaries[0].Case != unix || summaries[1].Case != tcp {
+		t.Fatalf("Expected unix then tcp, got %+v", summaries)
+	}
+	s := summaries[0]
+	// t(0.975, 2) * 10 / sqrt(3)
+	if s.Trials != 3 || s.Mean.Mean != 20 || math.Abs(s.Mean.HalfWidth-24.841) > 0.001 {
+		t.Errorf("Unexpected mean latency %+v over %d trials", s.Mean, s.Trials)
+	}
+	if s.OpsPerSec.Mean != 100 || s.OpsPerSec.HalfWidth != 0 {
+		t.Errorf("Unexpected ops/s %+v", s.OpsPerSec)
+	}
+
+	var buf strings.Builder
+	if err := WriteSummaries(&buf, summaries); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "20ns ± 124.2%") {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+}
+
+func TestCaseRate(t *testing.T) {
+	c := Case{Transport: "unix", Size: 64, Concurrency: 2, Rate: 5000}
+	if got, want := c.String(), "unix/size=64/conc=2/rate=5000"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	r := &Result{Case: c}
+	if record := r.Record(); record.Name() != c.String() {
+		t.Errorf("Record name %q does not match case %q", record.Name(), c.String())
+	}
+	c.Rate = -1
+	if err := c.Validate(); err == nil {
+		t.Error("Expected error for negative rate")
+	}
+}
+
+func TestCapacities(t *testing.T) {
+	unix := Case{Transport: "unix", Concurrency: 1}
+	result := func(rate float64, ops, dropped int, p99 time.Duration) *Result {
+		c := unix
+		c.Rate = rate
+		return &Result{Case: c, Ops: ops, Dropped: dropped, Elapsed: time.Second, Latency: Latency{P99: p99}}
+	}
+	results := []*Result{
+		result(1000, 1000, 0, 50*time.Microsecond),
+		result(2000, 2000, 0, 80*time.Microsecond),
+		// Kept up with the rate but over the SLO
+		result(4000, 3990, 10, 3*time.Millisecond),
+		// Fell behind
+		result(8000, 5000, 3000, 20*time.Millisecond),
+	}
+	if results[2].Saturated(0) || !results[2].Saturated(time.Millisecond) || !results[3].Saturated(0) {
+		t.Error("Unexpected saturation")
+	}
+	if (&Result{Case: Case{Transport: "unix"}, Ops: 1}).Saturated(time.Nanosecond) {
+		t.Error("A closed loop result cannot saturate")
+	}
+
+	capacities := Capacities(results, time.Millisecond)
+	if len(capacities) != 1 || capacities[0].Case != unix {
+		t.Fatalf("Expected one capacity for %v, got %+v", unix, capacities)
+	}
+	if c := capacities[0]; c.Best != results[1] || c.Saturated != results[2] {
+		t.Errorf("Expected best at 2000 and saturated at 4000, got %+v", c)
+	}
+
+	var buf strings.Builder
+	if err := WriteCapacities(&buf, capacities, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "unix - 0 1 2000 2000 80µs 4000" {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+}
+
+func TestSweepOptions(t *testing.T) {
+	c := Case{Transport: "unix", Concurrency: 1}
+	for _, tt := range []struct {
+		name  string
+		opts  Options
+		sweep SweepOptions
+	}{
+		{"zero start", Options{}, SweepOptions{Factor: 2}},
+		{"factor 1", Options{}, SweepOptions{Start: 1000, Factor: 1}},
+		{"calls without end", Options{Calls: 1000}, SweepOptions{Start: 1000, Factor: 2}},
+	} {
+		if _, err := Sweep(context.Background(), c, tt.opts, tt.sweep); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
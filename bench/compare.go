@@ -0,0 +1,193 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Samples maps each measured case to the values of one metric from every
+// time it was run. Lower values are better.
+type Samples map[string][]float64
+
+// recordMetrics are the Record fields Samples can be taken from, by their
+// JSON names. Total CPU times are divided by the number of calls and named
+// with _per_op like the other per call metrics.
+var recordMetrics = map[string]func(r *Record) float64{
+	"mean_ns":              func(r *Record) float64 { return float64(r.MeanNs) },
+	"p50_ns":               func(r *Record) float64 { return float64(r.P50Ns) },
+	"p90_ns":               func(r *Record) float64 { return float64(r.P90Ns) },
+	"p99_ns":               func(r *Record) float64 { return float64(r.P99Ns) },
+	"max_ns":               func(r *Record) float64 { return float64(r.MaxNs) },
+	"host_cpu_ns_per_op":   func(r *Record) float64 { return float64(r.HostCPUNs) / float64(max(r.Ops, 1)) },
+	"plugin_cpu_ns_per_op": func(r *Record) float64 { return float64(r.PluginCPUNs) / float64(max(r.Ops, 1)) },
+	"allocs_per_op":        func(r *Record) float64 { return r.AllocsPerOp },
+	"alloc_bytes_per_op":   func(r *Record) float64 { return r.AllocBytesPerOp },
+	"cpu_ns_per_op":        func(r *Record) float64 { return r.CPUNsPerOp },
+	"ctx_switches_per_op":  func(r *Record) float64 { return r.CtxSwitchesPerOp },
+	"rw_syscalls_per_op":   func(r *Record) float64 { return r.RWSyscallsPerOp },
+}
+
+// Metrics returns the names of the metrics ReportSamples accepts.
+func Metrics() []string {
+	names := make([]string, 0, len(recordMetrics))
+	for name := range recordMetrics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Name returns the name Samples use for the case r measured.
+func (r *Record) Name() string {
+	name := r.Transport
+	if r.Wait != "" {
+		name += "/" + r.Wait
+	}
+	name = fmt.Sprintf("%s/size=%d/conc=%d", name, r.PayloadSize, r.Concurrency)
+	if r.TargetRate > 0 {
+		name += fmt.Sprintf("/rate=%g", r.TargetRate)
+	}
+	return name
+}
+
+// ReportSamples returns metric from each result in report.
+func ReportSamples(report *Report, metric string) (Samples, error) {
+	value, ok := recordMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q, want one of %s", metric, strings.Join(Metrics(), ", "))
+	}
+	samples := Samples{}
+	for i := range report.Results {
+		r := &report.Results[i]
+		samples[r.Name()] = append(samples[r.Name()], value(r))
+	}
+	return samples, nil
+}
+
+// ParseBenchOutput returns the values with unit, such as "ns/op", from the
+// output of go test -bench. Run with -count to get more than one value for
+// each benchmark.
+func ParseBenchOutput(r io.Reader, unit string) (Samples, error) {
+	samples := Samples{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		// After the iteration count come value and unit pairs
+		for i := 2; i+1 < len(fields); i += 2 {
+			if fields[i+1] != unit {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad %s value %q", fields[0], unit, fields[i])
+			}
+			samples[fields[0]] = append(samples[fields[0]], v)
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// ReadSamples reads metric from data, which is either a report written by
+// WriteJSON or the output of go test -bench. For go test output metric is
+// a unit such as "ns/op". An empty metric means the mean latency.
+func ReadSamples(data []byte, metric string) (Samples, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		report, err := ReadJSON(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if metric == "" {
+			metric = "mean_ns"
+		}
+		return ReportSamples(report, metric)
+	}
+	if metric == "" {
+		metric = "ns/op"
+	}
+	return ParseBenchOutput(bytes.NewReader(data), metric)
+}
+
+// Comparison is the change in a metric for one case between a baseline and
+// new results.
+type Comparison struct {
+	Name string
+	// Old and New are the medians of the baseline and new samples.
+	Old, New float64
+	// OldN and NewN are the number of samples.
+	OldN, NewN int
+	// Delta is the relative change of New from Old.
+	Delta float64
+	// P is the p-value of the Mann-Whitney U test between the samples.
+	P float64
+}
+
+// Significant reports whether the change is unlikely to be noise at
+// significance level alpha.
+func (c *Comparison) Significant(alpha float64) bool {
+	return c.P < alpha
+}
+
+// Regression reports whether the metric got significantly worse by more
+// than threshold, a fraction of the baseline.
+func (c *Comparison) Regression(alpha, threshold float64) bool {
+	return c.Significant(alpha) && c.Delta > threshold
+}
+
+// Compare compares the cases in both old and latest, in name order.
+func Compare(old, latest Samples) []Comparison {
+	var comparisons []Comparison
+	for name, oldValues := range old {
+		newValues, ok := latest[name]
+		if !ok {
+			continue
+		}
+		c := Comparison{
+			Name: name,
+			Old:  median(oldValues),
+			New:  median(newValues),
+			OldN: len(oldValues),
+			NewN: len(newValues),
+			P:    MannWhitney(oldValues, newValues),
+		}
+		if c.Old != 0 {
+			c.Delta = c.New/c.Old - 1
+		}
+		comparisons = append(comparisons, c)
+	}
+	slices.SortFunc(comparisons, func(a, b Comparison) int { return strings.Compare(a.Name, b.Name) })
+	return comparisons
+}
+
+// WriteComparisons writes comparisons as a table in the style of benchstat
+// and returns the number of regressions. Changes that are not significant
+// at alpha are shown as "~".
+func WriteComparisons(w io.Writer, comparisons []Comparison, alpha, threshold float64) (int, error) {
+	regressions := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\told\tnew\tdelta\t\t")
+	for _, c := range comparisons {
+		delta := "~"
+		if c.Significant(alpha) {
+			delta = fmt.Sprintf("%+.2f%%", c.Delta*100)
+		}
+		verdict := ""
+		if c.Regression(alpha, threshold) {
+			verdict = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(tw, "%s\t%.4g (n=%d)\t%.4g (n=%d)\t%s\t(p=%.3f)\t%s\n", c.Name, c.Old, c.OldN, c.New, c.NewN, delta, c.P, verdict)
+	}
+	return regressions, tw.Flush()
+}
@@ -0,0 +1,177 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Sysctls are the kernel settings recorded in Env because they affect IPC
+// latency.
+var Sysctls = []string{
+	"net.core.busy_poll",
+	"net.core.busy_read",
+	"net.core.rmem_default",
+	"net.core.wmem_default",
+	"net.unix.max_dgram_qlen",
+	"kernel.numa_balancing",
+	"kernel.sched_autogroup_enabled",
+}
+
+// Env describes the machine and build a run was made on. Settings that
+// cannot be read, for example on systems other than Linux, are left empty.
+type Env struct {
+	Hostname   string    `json:"hostname"`
+	GOOS       string    `json:"goos"`
+	GOARCH     string    `json:"goarch"`
+	GoVersion  string    `json:"go_version"`
+	NumCPU     int       `json:"num_cpu"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Time       time.Time `json:"time"`
+
+	Kernel   string `json:"kernel"`
+	CPUModel string `json:"cpu_model"`
+	// Governor is the cpufreq scaling governor of CPU 0.
+	Governor string `json:"cpu_governor"`
+	// CgroupCPUMax is the cgroup CPU bandwidth limit as a quota and period
+	// in microseconds, in the format of the cgroup v2 cpu.max file. A quota
+	// of "max" means no limit.
+	CgroupCPUMax string `json:"cgroup_cpu_max"`
+	// SMT is the simultaneous multithreading control state, such as "on",
+	// "off" or "notsupported".
+	SMT         string `json:"smt"`
+	LoopbackMTU int    `json:"loopback_mtu"`
+	// Sysctls maps each of the package's Sysctls to its value.
+	Sysctls map[string]string `json:"sysctls"`
+}
+
+// CaptureEnv returns the Env of the running process.
+func CaptureEnv() Env {
+	hostname, _ := os.Hostname()
+	env := Env{
+		Hostname:     hostname,
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Time:         time.Now().UTC().Truncate(time.Second),
+		Kernel:       readLine("/proc/sys/kernel/osrelease"),
+		CPUModel:     cpuModel(),
+		Governor:     readLine("/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor"),
+		CgroupCPUMax: cgroupCPUMax(),
+		SMT:          readLine("/sys/devices/system/cpu/smt/control"),
+		Sysctls:      map[string]string{},
+	}
+	if lo, err := net.InterfaceByName("lo"); err == nil {
+		env.LoopbackMTU = lo.MTU
+	}
+	for _, name := range Sysctls {
+		env.Sysctls[name] = readLine(filepath.Join("/proc/sys", strings.ReplaceAll(name, ".", "/")))
+	}
+	return env
+}
+
+// WriteConfig writes the settings of env that go test does not print as
+// "key: value" lines, which benchstat reads as configuration of the results
+// that follow.
+func WriteConfig(w io.Writer, env Env) {
+	config := [][2]string{
+		{"kernel", env.Kernel},
+		{"cpu-model", env.CPUModel},
+		{"cpu-governor", env.Governor},
+		{"cgroup-cpu-max", env.CgroupCPUMax},
+		{"smt", env.SMT},
+		{"gomaxprocs", fmt.Sprint(env.GOMAXPROCS)},
+		{"go-version", env.GoVersion},
+		{"loopback-mtu", fmt.Sprint(env.LoopbackMTU)},
+	}
+	for _, name := range Sysctls {
+		config = append(config, [2]string{name, env.Sysctls[name]})
+	}
+	for _, kv := range config {
+		if kv[1] != "" {
+			fmt.Fprintf(w, "%s: %s\n", kv[0], kv[1])
+		}
+	}
+}
+
+// readLine returns the first line of the file at path, or "" if it cannot
+// be read.
+func readLine(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(line)
+}
+
+// cpuModel returns the model name of the first CPU in /proc/cpuinfo.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// cgroupCPUMax returns the CPU bandwidth limit of this process's cgroup in
+// the format of the cgroup v2 cpu.max file, reading the cgroup v1 CFS quota
+// if there is no v2 limit.
+func cgroupCPUMax() string {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var v1, v2 string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are hierarchy-ID:controllers:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			v2 = fields[2]
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "cpu" {
+				v1 = fields[2]
+			}
+		}
+	}
+
+	if v2 != "" {
+		if max := readLine(filepath.Join("/sys/fs/cgroup", v2, "cpu.max")); max != "" {
+			return max
+		}
+	}
+	if v1 != "" {
+		dir := filepath.Join("/sys/fs/cgroup/cpu", v1)
+		quota, period := readLine(filepath.Join(dir, "cpu.cfs_quota_us")), readLine(filepath.Join(dir, "cpu.cfs_period_us"))
+		if quota == "-1" {
+			quota = "max"
+		}
+		if quota != "" && period != "" {
+			return quota + " " + period
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package bench
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCaptureEnv(t *testing.T) {
+	env := CaptureEnv()
+	if env.GOMAXPROCS != runtime.GOMAXPROCS(0) || env.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected Go settings: %+v", env)
+	}
+	for _, name := range Sysctls {
+		if _, ok := env.Sysctls[name]; !ok {
+			t.Errorf("Sysctl %s missing", name)
+		}
+	}
+	if runtime.GOOS == "linux" {
+		if env.Kernel == "" {
+			t.Error("Kernel version missing")
+		}
+		if env.LoopbackMTU == 0 {
+			t.Error("Loopback MTU missing")
+		}
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	env := Env{Kernel: "6.1.0", GOMAXPROCS: 4, Sysctls: map[string]string{"net.core.busy_poll": "50"}}
+	var buf strings.Builder
+	WriteConfig(&buf, env)
+	got := buf.String()
+	for _, line := range []string{"kernel: 6.1.0\n", "gomaxprocs: 4\n", "net.core.busy_poll: 50\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("Missing %q in:\n%s", line, got)
+		}
+	}
+	// Settings that could not be read are left out
+	if strings.Contains(got, "cpu-governor") || strings.Contains(got, "net.core.busy_read") {
+		t.Errorf("Empty settings written:\n%s", got)
+	}
+}
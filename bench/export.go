@@ -0,0 +1,252 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the version of the Report and Record layout. Fields are
+// only ever added within a version; renaming or removing one bumps it.
+//
+// Version 2 renamed syscalls_per_op to rw_syscalls_per_op, as it only ever
+// counted reads and writes.
+const SchemaVersion = 2
+
+// Report is the machine readable output of a run.
+type Report struct {
+	Schema  int      `json:"schema"`
+	Env     Env      `json:"env"`
+	Results []Record `json:"results"`
+}
+
+// Record is a Result flattened for export. Durations are in nanoseconds.
+type Record struct {
+	Transport   string `json:"transport"`
+	Wait        string `json:"wait"`
+	PayloadSize int    `json:"payload_size"`
+	Concurrency int    `json:"concurrency"`
+
+	Ops       int     `json:"ops"`
+	ElapsedNs int64   `json:"elapsed_ns"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+
+	MeanNs int64 `json:"mean_ns"`
+	P50Ns  int64 `json:"p50_ns"`
+	P90Ns  int64 `json:"p90_ns"`
+	P99Ns  int64 `json:"p99_ns"`
+	MaxNs  int64 `json:"max_ns"`
+
+	HostCPUNs   int64 `json:"host_cpu_ns"`
+	PluginCPUNs int64 `json:"plugin_cpu_ns"`
+
+	AllocsPerOp     float64 `json:"allocs_per_op"`
+	AllocBytesPerOp float64 `json:"alloc_bytes_per_op"`
+
+	// HostCPUs and PluginCPUs are CPU lists such as "0-3,8", empty if not
+	// pinned.
+	HostCPUs   string `json:"host_cpus"`
+	PluginCPUs string `json:"plugin_cpus"`
+
+	// CPUNsPerOp is the CPU time of the host and plugins together per call.
+	CPUNsPerOp       float64 `json:"cpu_ns_per_op"`
+	CtxSwitchesPerOp float64 `json:"ctx_switches_per_op"`
+	// RWSyscallsPerOp counts only read and write system calls, as
+	// procstat.Usage.Syscalls does.
+	RWSyscallsPerOp float64 `json:"rw_syscalls_per_op"`
+
+	// TargetRate is the calls per second scheduled, or zero if each call
+	// was made as soon as the previous one returned. Latencies are then
+	// measured from when each call was scheduled.
+	TargetRate float64 `json:"target_rate"`
+	Dropped    int     `json:"dropped"`
+}
+
+// NewReport returns the report of results run in env.
+func NewReport(env Env, results []*Result) *Report {
+	report := &Report{Schema: SchemaVersion, Env: env, Results: []Record{}}
+	for _, r := range results {
+		report.Results = append(report.Results, r.Record())
+	}
+	return report
+}
+
+// Record returns r flattened for export.
+func (r *Result) Record() Record {
+	record := Record{
+		Transport:   r.Transport,
+		PayloadSize: r.Size,
+		Concurrency: r.Concurrency,
+		Ops:         r.Ops,
+		ElapsedNs:   r.Elapsed.Nanoseconds(),
+		OpsPerSec:   r.OpsPerSec(),
+		MeanNs:      r.Latency.Mean.Nanoseconds(),
+		P50Ns:       r.Latency.P50.Nanoseconds(),
+		P90Ns:       r.Latency.P90.Nanoseconds(),
+		P99Ns:       r.Latency.P99.Nanoseconds(),
+		MaxNs:       r.Latency.Max.Nanoseconds(),
+		HostCPUNs:   r.HostCPU.Nanoseconds(),
+		PluginCPUNs: r.PluginCPU.Nanoseconds(),
+		HostCPUs:    cpuList(r.HostCPUs),
+		PluginCPUs:  cpuList(r.PluginCPUs),
+		TargetRate:  r.Rate,
+		Dropped:     r.Dropped,
+	}
+	if r.Transport == "mmap" {
+		record.Wait = r.Wait.String()
+	}
+	if r.Ops > 0 {
+		record.AllocsPerOp = float64(r.Allocs) / float64(r.Ops)
+		record.AllocBytesPerOp = float64(r.AllocBytes) / float64(r.Ops)
+		record.CPUNsPerOp = float64(r.HostCPU+r.PluginCPU) / float64(r.Ops)
+		record.CtxSwitchesPerOp = float64(r.CtxSwitches) / float64(r.Ops)
+		record.RWSyscallsPerOp = float64(r.Syscalls) / float64(r.Ops)
+	}
+	return record
+}
+
+// WriteJSON writes report as indented JSON.
+func WriteJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// ReadJSON reads a report written by WriteJSON, of this or the previous
+// schema version.
+func ReadJSON(r io.Reader) (*Report, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	switch report.Schema {
+	case SchemaVersion:
+	case 1:
+		var v1 struct {
+			Results []struct {
+				SyscallsPerOp float64 `json:"syscalls_per_op"`
+			} `json:"results"`
+		}
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+		for i, r := range v1.Results {
+			report.Results[i].RWSyscallsPerOp = r.SyscallsPerOp
+		}
+		report.Schema = SchemaVersion
+	default:
+		return nil, fmt.Errorf("report schema version %d is not %d", report.Schema, SchemaVersion)
+	}
+	return &report, nil
+}
+
+// csvColumn is a CSV column and how to get its value from a report row.
+type csvColumn struct {
+	name  string
+	value func(env *Env, r *Record) string
+}
+
+// csvColumns are the columns of WriteCSV in order. New columns go at the
+// end. A column for each of Sysctls follows them all, so that recording
+// another sysctl moves no other column.
+var csvColumns = []csvColumn{
+	{"schema", func(*Env, *Record) string { return strconv.Itoa(SchemaVersion) }},
+	{"transport", func(_ *Env, r *Record) string { return r.Transport }},
+	{"wait", func(_ *Env, r *Record) string { return r.Wait }},
+	{"payload_size", func(_ *Env, r *Record) string { return strconv.Itoa(r.PayloadSize) }},
+	{"concurrency", func(_ *Env, r *Record) string { return strconv.Itoa(r.Concurrency) }},
+	{"ops", func(_ *Env, r *Record) string { return strconv.Itoa(r.Ops) }},
+	{"elapsed_ns", func(_ *Env, r *Record) string { return formatInt(r.ElapsedNs) }},
+	{"ops_per_sec", func(_ *Env, r *Record) string { return formatFloat(r.OpsPerSec) }},
+	{"mean_ns", func(_ *Env, r *Record) string { return formatInt(r.MeanNs) }},
+	{"p50_ns", func(_ *Env, r *Record) string { return formatInt(r.P50Ns) }},
+	{"p90_ns", func(_ *Env, r *Record) string { return formatInt(r.P90Ns) }},
+	{"p99_ns", func(_ *Env, r *Record) string { return formatInt(r.P99Ns) }},
+	{"max_ns", func(_ *Env, r *Record) string { return formatInt(r.MaxNs) }},
+	{"host_cpu_ns", func(_ *Env, r *Record) string { return formatInt(r.HostCPUNs) }},
+	{"plugin_cpu_ns", func(_ *Env, r *Record) string { return formatInt(r.PluginCPUNs) }},
+	{"allocs_per_op", func(_ *Env, r *Record) string { return formatFloat(r.AllocsPerOp) }},
+	{"alloc_bytes_per_op", func(_ *Env, r *Record) string { return formatFloat(r.AllocBytesPerOp) }},
+	{"hostname", func(env *Env, _ *Record) string { return env.Hostname }},
+	{"goos", func(env *Env, _ *Record) string { return env.GOOS }},
+	{"goarch", func(env *Env, _ *Record) string { return env.GOARCH }},
+	{"go_version", func(env *Env, _ *Record) string { return env.GoVersion }},
+	{"num_cpu", func(env *Env, _ *Record) string { return strconv.Itoa(env.NumCPU) }},
+	{"gomaxprocs", func(env *Env, _ *Record) string { return strconv.Itoa(env.GOMAXPROCS) }},
+	{"time", func(env *Env, _ *Record) string { return env.Time.Format("2006-01-02T15:04:05Z07:00") }},
+	{"kernel", func(env *Env, _ *Record) string { return env.Kernel }},
+	{"cpu_model", func(env *Env, _ *Record) string { return env.CPUModel }},
+	{"cpu_governor", func(env *Env, _ *Record) string { return env.Governor }},
+	{"cgroup_cpu_max", func(env *Env, _ *Record) string { return env.CgroupCPUMax }},
+	{"smt", func(env *Env, _ *Record) string { return env.SMT }},
+	{"loopback_mtu", func(env *Env, _ *Record) string { return strconv.Itoa(env.LoopbackMTU) }},
+	{"host_cpus", func(_ *Env, r *Record) string { return r.HostCPUs }},
+	{"plugin_cpus", func(_ *Env, r *Record) string { return r.PluginCPUs }},
+	{"cpu_ns_per_op", func(_ *Env, r *Record) string { return formatFloat(r.CPUNsPerOp) }},
+	{"ctx_switches_per_op", func(_ *Env, r *Record) string { return formatFloat(r.CtxSwitchesPerOp) }},
+	{"rw_syscalls_per_op", func(_ *Env, r *Record) string { return formatFloat(r.RWSyscallsPerOp) }},
+	{"target_rate", func(_ *Env, r *Record) string { return formatFloat(r.TargetRate) }},
+	{"dropped", func(_ *Env, r *Record) string { return strconv.Itoa(r.Dropped) }},
+}
+
+func init() {
+	for _, name := range Sysctls {
+		csvColumns = append(csvColumns, csvColumn{"sysctl." + name, func(env *Env, _ *Record) string { return env.Sysctls[name] }})
+	}
+}
+
+// WriteCSV writes report as CSV with a header row and one row per result.
+// The environment is repeated on every row so rows stand alone in a
+// spreadsheet.
+func WriteCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	row := make([]string, len(csvColumns))
+	for i, col := range csvColumns {
+		row[i] = col.name
+	}
+	cw.Write(row)
+	for i := range report.Results {
+		for j, col := range csvColumns {
+			row[j] = col.value(&report.Env, &report.Results[i])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// cpuList formats cpus as a CPU list such as "0-3,8", with each run of
+// consecutive CPUs as a range.
+func cpuList(cpus []int) string {
+	cpus = slices.Sorted(slices.Values(cpus))
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i + 1
+		for j < len(cpus) && cpus[j] == cpus[j-1]+1 {
+			j++
+		}
+		if j-i == 1 {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, strconv.Itoa(cpus[i])+"-"+strconv.Itoa(cpus[j-1]))
+		}
+		i = j
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
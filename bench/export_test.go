@@ -0,0 +1,109 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+func testReport() *Report {
+	env := Env{Hostname: "box", GOOS: "linux", GOARCH: "amd64", GoVersion: "go1.24", NumCPU: 8, GOMAXPROCS: 8, Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	return NewReport(env, []*Result{{
+		Case:       Case{Transport: "mmap", Wait: protocol.WaitSleep, Size: 64, Concurrency: 2},
+		Ops:        1000,
+		Elapsed:    time.Second,
+		Latency:    Latency{Mean: 2 * time.Microsecond, P50: time.Microsecond, P90: 3 * time.Microsecond, P99: 5 * time.Microsecond, Max: 9 * time.Microsecond},
+		HostCPU:    700 * time.Millisecond,
+		PluginCPU:  1400 * time.Millisecond,
+		Allocs:     3000,
+		AllocBytes: 64000,
+	}})
+}
+
+func TestReportJSONRoundTrip(t *testing.T) {
+	report := testReport()
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("Round trip: got %+v, want %+v", got, report)
+	}
+
+	record := got.Results[0]
+	if record.Wait != "sleep" || record.MeanNs != 2000 || record.AllocsPerOp != 3 || record.OpsPerSec != 1000 {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+
+	// Version 1 named rw_syscalls_per_op syscalls_per_op
+	v1, err := ReadJSON(strings.NewReader(`{"schema": 1, "results": [{"transport": "unix", "syscalls_per_op": 4}]}`))
+	if err != nil || v1.Schema != SchemaVersion || v1.Results[0].RWSyscallsPerOp != 4 {
+		t.Errorf("ReadJSON of schema 1: got %+v, %v", v1, err)
+	}
+
+	if _, err := ReadJSON(strings.NewReader(`{"schema": 99}`)); err == nil {
+		t.Error("Expected error for unknown schema version")
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, testReport()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected header and 1 row, got %d rows", len(rows))
+	}
+
+	// The leading columns are part of the stable schema
+	header := strings.Join(rows[0][:9], ",")
+	if want := "schema,transport,wait,payload_size,concurrency,ops,elapsed_ns,ops_per_sec,mean_ns"; header != want {
+		t.Errorf("Header: got %s, want %s", header, want)
+	}
+	// The sysctl columns come last, so more can be recorded
+	sysctls := rows[0][len(rows[0])-len(Sysctls):]
+	for i, name := range Sysctls {
+		if sysctls[i] != "sysctl."+name {
+			t.Errorf("Column %d from the end: got %s, want sysctl.%s", len(Sysctls)-i, sysctls[i], name)
+		}
+	}
+	row := map[string]string{}
+	for i, name := range rows[0] {
+		row[name] = rows[1][i]
+	}
+	for name, want := range map[string]string{"transport": "mmap", "p99_ns": "5000", "plugin_cpu_ns": "1400000000", "alloc_bytes_per_op": "64", "hostname": "box", "time": "2025-01-02T03:04:05Z"} {
+		if row[name] != want {
+			t.Errorf("%s: got %q, want %q", name, row[name], want)
+		}
+	}
+}
+
+func TestCPUList(t *testing.T) {
+	for _, tt := range []struct {
+		cpus []int
+		want string
+	}{
+		{nil, ""},
+		{[]int{2}, "2"},
+		{[]int{0, 1}, "0-1"},
+		{[]int{0, 1, 2, 3, 8}, "0-3,8"},
+		{[]int{11, 10, 8, 0, 2, 1}, "0-2,8,10-11"},
+	} {
+		if got := cpuList(tt.cpus); got != tt.want {
+			t.Errorf("cpuList(%v): got %q, want %q", tt.cpus, got, tt.want)
+		}
+	}
+}
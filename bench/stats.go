@@ -0,0 +1,249 @@
+package bench
+
+import (
+	"math"
+	"slices"
+)
+
+// exactLimit is the largest sample size for which MannWhitney computes the
+// exact distribution of U rather than approximating it.
+const exactLimit = 50
+
+// MannWhitney returns the two-sided p-value of the Mann-Whitney U test that x
+// and y come from the same distribution. Small samples without ties use the
+// exact distribution of U, others its normal approximation with a tie
+// correction.
+func MannWhitney(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	// Rank the pooled samples, giving ties their average rank
+	type value struct {
+		v     float64
+		fromX bool
+	}
+	pooled := make([]value, 0, n1+n2)
+	for _, v := range x {
+		pooled = append(pooled, value{v, true})
+	}
+	for _, v := range y {
+		pooled = append(pooled, value{v, false})
+	}
+	slices.SortFunc(pooled, func(a, b value) int {
+		switch {
+		case a.v < b.v:
+			return -1
+		case a.v > b.v:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	var rankSumX, tieTerm float64
+	ties := false
+	for i := 0; i < len(pooled); {
+		j := i
+		for j < len(pooled) && pooled[j].v == pooled[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if pooled[k].fromX {
+				rankSumX += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	u := rankSumX - float64(n1*(n1+1))/2
+
+	if !ties && n1 <= exactLimit && n2 <= exactLimit {
+		return exactP(n1, n2, u)
+	}
+
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	// Continuity correction towards the mean
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		return 1
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactP returns the two-sided p-value of u for samples of size n1 and n2
+// from the exact distribution of U.
+func exactP(n1, n2 int, u float64) float64 {
+	// counts[k] is the number of orderings of the pooled samples for which
+	// U is k, built up one sample size at a time
+	maxU := n1 * n2
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = make([]float64, maxU+1)
+		prev[j][0] = 1
+	}
+	for i := 1; i <= n1; i++ {
+		next := make([][]float64, n2+1)
+		next[0] = make([]float64, maxU+1)
+		next[0][0] = 1
+		for j := 1; j <= n2; j++ {
+			next[j] = make([]float64, maxU+1)
+			for k := 0; k <= i*j; k++ {
+				// The largest pooled value is either from x, contributing j
+				// to U, or from y, contributing nothing
+				if k >= j {
+					next[j][k] += prev[j][k-j]
+				}
+				next[j][k] += next[j-1][k]
+			}
+		}
+		prev = next
+	}
+	counts := prev[n2]
+
+	var total, below, above float64
+	for k, c := range counts {
+		total += c
+		if float64(k) <= u {
+			below += c
+		}
+		if float64(k) >= u {
+			above += c
+		}
+	}
+	return math.Min(1, 2*math.Min(below, above)/total)
+}
+
+// median returns the median of samples without modifying them.
+func median(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(samples))
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// ConfidenceInterval returns the mean of samples and the half width of its
+// confidence interval at level, such as 0.95, from Student's t distribution.
+// The samples are assumed to be independent, such as separate trials. The
+// half width is infinite for fewer than two samples.
+func ConfidenceInterval(samples []float64, level float64) (mean, halfWidth float64) {
+	n := len(samples)
+	if n == 0 {
+		return 0, math.Inf(1)
+	}
+	for _, v := range samples {
+		mean += v
+	}
+	mean /= float64(n)
+	if n < 2 {
+		return mean, math.Inf(1)
+	}
+
+	var squares float64
+	for _, v := range samples {
+		squares += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(squares / float64(n-1))
+	t := studentTQuantile(1-(1-level)/2, float64(n-1))
+	return mean, t * stddev / math.Sqrt(float64(n))
+}
+
+// studentTQuantile returns the value below which a fraction p, at least
+// 0.5, of Student's t distribution with df degrees of freedom lies.
+func studentTQuantile(p, df float64) float64 {
+	// The CDF is increasing, so bisect on it after finding an upper bound
+	cdf := func(t float64) float64 {
+		return 1 - betaInc(df/2, 0.5, df/(df+t*t))/2
+	}
+	low, high := 0.0, 1.0
+	for cdf(high) < p {
+		low, high = high, high*2
+	}
+	for range 100 {
+		mid := (low + high) / 2
+		if cdf(mid) < p {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// betaInc returns the regularized incomplete beta function I_x(a, b).
+func betaInc(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lgab, _ := math.Lgamma(a + b)
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly only on this side of the
+	// mean, so use the symmetry I_x(a, b) = 1 - I_1-x(b, a) on the other
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function by the modified Lentz method.
+func betaFraction(a, b, x float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+	for m := 1.0; m <= 300; m++ {
+		// Even step
+		num := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		f *= d * c
+		// Odd step
+		num = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		f *= delta
+		if math.Abs(delta-1) < 1e-12 {
+			break
+		}
+	}
+	return f
+}
@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMannWhitney(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		x, y []float64
+		want float64
+	}{
+		// U is 0, the most extreme of the C(10, 5) orderings at either end
+		{"separated", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{"interleaved", []float64{1, 3, 5, 7}, []float64{2, 4, 6, 8}, 48.0 / 70},
+		{"single", []float64{1}, []float64{2}, 1},
+		// Ties use the normal approximation
+		{"ties", []float64{1, 1, 2, 2, 3, 3}, []float64{3, 3, 4, 4, 5, 5}, 0.0109},
+		{"identical", []float64{4, 4, 4}, []float64{4, 4, 4}, 1},
+		{"empty", nil, []float64{1}, 1},
+	} {
+		if got := MannWhitney(tt.x, tt.y); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s: got p=%.4f, want %.4f", tt.name, got, tt.want)
+		}
+		if got, reverse := MannWhitney(tt.x, tt.y), MannWhitney(tt.y, tt.x); math.Abs(got-reverse) > 1e-9 {
+			t.Errorf("%s: p=%.4f one way and %.4f the other", tt.name, got, reverse)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old, err := ParseBenchOutput(strings.NewReader(`goos: linux
+BenchmarkUnix-8   	  266845	      4830 ns/op	     128 B/op
+BenchmarkUnix-8   	  266845	      4810 ns/op	     128 B/op
+BenchmarkUnix-8   	  266845	      4850 ns/op	     128 B/op
+BenchmarkUnix-8   	  266845	      4820 ns/op	     128 B/op
+BenchmarkUnix-8   	  266845	      4840 ns/op	     128 B/op
+BenchmarkTCP-8    	   63421	     16684 ns/op	     128 B/op
+BenchmarkTCP-8    	   63421	     16600 ns/op	     128 B/op
+BenchmarkMmap-8   	  510157	      2364 ns/op
+PASS
+`), "ns/op")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old["BenchmarkUnix-8"]) != 5 || len(old["BenchmarkTCP-8"]) != 2 {
+		t.Fatalf("Unexpected samples: %v", old)
+	}
+
+	latest := Samples{
+		"BenchmarkUnix-8": {5830, 5810, 5850, 5820, 5840},
+		"BenchmarkTCP-8":  {19000, 14000},
+	}
+	comparisons := Compare(old, latest)
+	if len(comparisons) != 2 || comparisons[0].Name != "BenchmarkTCP-8" {
+		t.Fatalf("Unexpected comparisons: %+v", comparisons)
+	}
+
+	// Two samples each can never be significant
+	if comparisons[0].Regression(0.05, 0.05) {
+		t.Errorf("TCP flagged as a regression: %+v", comparisons[0])
+	}
+	unix := comparisons[1]
+	if !unix.Regression(0.05, 0.05) || math.Abs(unix.Delta-1000.0/4830) > 1e-9 {
+		t.Errorf("Unix not flagged as a regression: %+v", unix)
+	}
+	if unix.Regression(0.05, 0.25) {
+		t.Errorf("Unix flagged as a regression beyond 25%%: %+v", unix)
+	}
+
+	var buf strings.Builder
+	regressions, err := WriteComparisons(&buf, comparisons, 0.05, 0.05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regressions != 1 || !strings.Contains(buf.String(), "REGRESSION") {
+		t.Errorf("Expected 1 regression, got %d:\n%s", regressions, buf.String())
+	}
+}
+
+func TestStudentTQuantile(t *testing.T) {
+	for _, tt := range []struct {
+		p, df, want float64
+	}{
+		{0.975, 1, 12.706},
+		{0.975, 4, 2.776},
+		{0.975, 30, 2.042},
+		{0.995, 10, 3.169},
+		{0.5, 7, 0},
+	} {
+		if got := studentTQuantile(tt.p, tt.df); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("t(%v, df=%v): got %.4f, want %.3f", tt.p, tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestConfidenceInterval(t *testing.T) {
+	mean, halfWidth := ConfidenceInterval([]float64{1, 2, 3, 4, 5}, 0.95)
+	// t(0.975, 4) * stddev / sqrt(n) = 2.776 * 1.5811 / 2.2361
+	if mean != 3 || math.Abs(halfWidth-1.963) > 0.001 {
+		t.Errorf("got %v ± %v, want 3 ± 1.963", mean, halfWidth)
+	}
+	if _, halfWidth := ConfidenceInterval([]float64{7}, 0.95); !math.IsInf(halfWidth, 1) {
+		t.Errorf("one sample: got half width %v, want infinity", halfWidth)
+	}
+}
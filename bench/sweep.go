@@ -0,0 +1,144 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// saturationFraction is the fraction of scheduled calls that must complete
+// for a transport to be keeping up with the rate.
+const saturationFraction = 0.95
+
+// Saturated reports whether r, run at a Rate, fell behind its schedule or
+// had a 99th percentile latency over slo. A zero slo only checks the rate.
+func (r *Result) Saturated(slo time.Duration) bool {
+	if r.Rate <= 0 {
+		return false
+	}
+	if float64(r.Ops) < saturationFraction*float64(r.Ops+r.Dropped) {
+		return true
+	}
+	return slo > 0 && r.Latency.P99 > slo
+}
+
+// SweepOptions configures Sweep.
+type SweepOptions struct {
+	// Start is the first rate in calls per second. Each next rate is the
+	// previous one multiplied by Factor.
+	Start, Factor float64
+	// Max is the highest rate to try. Zero means no limit.
+	Max float64
+	// SLO is the 99th percentile latency a rate must stay within. Zero
+	// means only keeping up with the rate counts.
+	//
+	// A run of Options.Calls calls makes every call however late, so it
+	// never falls behind the rate. Sweeping one needs Max or SLO set.
+	SLO time.Duration
+}
+
+// Sweep runs c at increasing rates until it saturates, as decided by
+// Result.Saturated, or exceeds sweep.Max, and returns each rate's result.
+// c.Rate is ignored.
+func Sweep(ctx context.Context, c Case, opts Options, sweep SweepOptions) ([]*Result, error) {
+	if sweep.Start <= 0 {
+		return nil, errors.New("sweep start rate must be positive")
+	}
+	if sweep.Factor <= 1 {
+		return nil, fmt.Errorf("sweep factor %g is not greater than 1", sweep.Factor)
+	}
+	if opts.Calls > 0 && sweep.Max == 0 && sweep.SLO == 0 {
+		return nil, errors.New("sweep of a fixed number of calls needs a max rate or an SLO")
+	}
+
+	var results []*Result
+	for rate := sweep.Start; sweep.Max == 0 || rate <= sweep.Max; rate *= sweep.Factor {
+		c.Rate = rate
+		r, err := Run(ctx, c, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r)
+		if r.Saturated(sweep.SLO) {
+			break
+		}
+	}
+	return results, nil
+}
+
+// Capacity is the throughput a case sustained in a sweep.
+type Capacity struct {
+	Case
+	// Best is the result at the highest rate that did not saturate, or nil
+	// if the first rate did.
+	Best *Result
+	// Saturated is the result at the first rate that saturated, or nil if
+	// none did.
+	Saturated *Result
+}
+
+// Capacities returns the capacity of each case in results, in the order
+// each first appears. Results with the same Case apart from Rate are taken
+// to be one sweep.
+func Capacities(results []*Result, slo time.Duration) []Capacity {
+	var order []Case
+	capacities := map[Case]*Capacity{}
+	for _, r := range results {
+		if r.Rate <= 0 {
+			continue
+		}
+		c := r.Case
+		c.Rate = 0
+		capacity, ok := capacities[c]
+		if !ok {
+			order = append(order, c)
+			capacity = &Capacity{Case: c}
+			capacities[c] = capacity
+		}
+		if r.Saturated(slo) {
+			if capacity.Saturated == nil || r.Rate < capacity.Saturated.Rate {
+				capacity.Saturated = r
+			}
+		} else if capacity.Best == nil || r.Rate > capacity.Best.Rate {
+			capacity.Best = r
+		}
+	}
+
+	list := make([]Capacity, len(order))
+	for i, c := range order {
+		list[i] = *capacities[c]
+	}
+	return list
+}
+
+// WriteCapacities writes capacities as a table of the highest rate each
+// case sustained within slo and the rate at which it saturated.
+func WriteCapacities(w io.Writer, capacities []Capacity, slo time.Duration) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	sloName := "p99"
+	if slo > 0 {
+		sloName = fmt.Sprintf("p99 (slo %v)", slo)
+	}
+	fmt.Fprintf(tw, "transport\twait\tsize\tconc\tmax rate\tops/s\t%s\tsaturated at\t\n", sloName)
+	for _, c := range capacities {
+		wait := "-"
+		if c.Transport == "mmap" {
+			wait = c.Wait.String()
+		}
+		rate, ops, p99, saturated := "-", "-", "-", "-"
+		if c.Best != nil {
+			rate = fmt.Sprintf("%g", c.Best.Rate)
+			ops = fmt.Sprintf("%.0f", c.Best.OpsPerSec())
+			p99 = c.Best.Latency.P99.String()
+		}
+		if c.Saturated != nil {
+			saturated = fmt.Sprintf("%g", c.Saturated.Rate)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+			c.Transport, wait, c.Size, c.Concurrency, rate, ops, p99, saturated)
+	}
+	return tw.Flush()
+}
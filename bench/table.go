@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteTable writes results as a table. Each result's mean latency is also
+// given relative to the fastest result with the same payload size,
+// concurrency and rate, to compare transports.
+func WriteTable(w io.Writer, results []*Result) error {
+	type key struct {
+		size, concurrency int
+		rate              float64
+	}
+	fastest := map[key]float64{}
+	for _, r := range results {
+		k := key{r.Size, r.Concurrency, r.Rate}
+		mean := float64(r.Latency.Mean)
+		if best, ok := fastest[k]; !ok || mean < best {
+			fastest[k] = mean
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "transport\twait\tsize\tconc\trate\tops\tops/s\tmean\tp50\tp90\tp99\tmax\tcpu/op\tctxsw/op\trw-syscalls/op\tvs best\t")
+	for _, r := range results {
+		wait := "-"
+		if r.Transport == "mmap" {
+			wait = r.Wait.String()
+		}
+		rate := "-"
+		if r.Rate > 0 {
+			rate = fmt.Sprintf("%g", r.Rate)
+		}
+		relative := "-"
+		if best := fastest[key{r.Size, r.Concurrency, r.Rate}]; best > 0 {
+			relative = fmt.Sprintf("%.2fx", float64(r.Latency.Mean)/best)
+		}
+		l := r.Latency
+		record := r.Record()
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%d\t%.0f\t%v\t%v\t%v\t%v\t%v\t%v\t%.2f\t%.2f\t%s\t\n",
+			r.Transport, wait, r.Size, r.Concurrency, rate, r.Ops, r.OpsPerSec(), l.Mean, l.P50, l.P90, l.P99, l.Max,
+			time.Duration(record.CPUNsPerOp), record.CtxSwitchesPerOp, record.RWSyscallsPerOp, relative)
+	}
+	return tw.Flush()
+}
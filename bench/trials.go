@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// Interval is an estimate of a metric over repeated trials: the mean of
+// the trials and the half width of its confidence interval.
+type Interval struct {
+	Mean, HalfWidth float64
+}
+
+// Relative returns the half width as a fraction of the mean.
+func (i Interval) Relative() float64 {
+	if i.Mean == 0 {
+		return math.Inf(1)
+	}
+	return i.HalfWidth / math.Abs(i.Mean)
+}
+
+// Summary is the estimate of each metric of a case from its trials.
+type Summary struct {
+	Case
+	// Trials is the number of results summarized.
+	Trials int
+	// Level is the confidence level of the intervals, such as 0.95.
+	Level float64
+
+	OpsPerSec Interval
+	// Mean, P50 and P99 are latencies in nanoseconds.
+	Mean Interval
+	P50  Interval
+	P99  Interval
+	// CPUPerOp is the CPU time of the host and plugins together per call in
+	// nanoseconds.
+	CPUPerOp Interval
+}
+
+// Summarize returns a summary of the trials of each case in results, in the
+// order each case first appears. A trial is one Run, so each trial starts
+// its own plugins and its metrics are independent of the other trials'.
+func Summarize(results []*Result, level float64) []Summary {
+	var order []Case
+	trials := map[Case][]*Result{}
+	for _, r := range results {
+		if _, ok := trials[r.Case]; !ok {
+			order = append(order, r.Case)
+		}
+		trials[r.Case] = append(trials[r.Case], r)
+	}
+
+	summaries := make([]Summary, 0, len(order))
+	for _, c := range order {
+		rs := trials[c]
+		interval := func(value func(r *Result) float64) Interval {
+			values := make([]float64, len(rs))
+			for i, r := range rs {
+				values[i] = value(r)
+			}
+			mean, halfWidth := ConfidenceInterval(values, level)
+			return Interval{mean, halfWidth}
+		}
+		summaries = append(summaries, Summary{
+			Case:      c,
+			Trials:    len(rs),
+			Level:     level,
+			OpsPerSec: interval((*Result).OpsPerSec),
+			Mean:      interval(func(r *Result) float64 { return float64(r.Latency.Mean) }),
+			P50:       interval(func(r *Result) float64 { return float64(r.Latency.P50) }),
+			P99:       interval(func(r *Result) float64 { return float64(r.Latency.P99) }),
+			CPUPerOp: interval(func(r *Result) float64 {
+				return float64(r.HostCPU+r.PluginCPU) / float64(max(r.Ops, 1))
+			}),
+		})
+	}
+	return summaries
+}
+
+// WriteSummaries writes summaries as a table of each metric's mean and
+// confidence interval, as "mean ± half width".
+func WriteSummaries(w io.Writer, summaries []Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "transport\twait\tsize\tconc\ttrials\tconf\tops/s\tmean\tp50\tp99\tcpu/op\t")
+	for _, s := range summaries {
+		wait := "-"
+		if s.Transport == "mmap" {
+			wait = s.Wait.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.0f%%\t%s\t%s\t%s\t%s\t%s\t\n",
+			s.Transport, wait, s.Size, s.Concurrency, s.Trials, s.Level*100,
+			formatInterval(s.OpsPerSec, func(v float64) string { return fmt.Sprintf("%.0f", v) }),
+			formatInterval(s.Mean, formatNs), formatInterval(s.P50, formatNs),
+			formatInterval(s.P99, formatNs), formatInterval(s.CPUPerOp, formatNs))
+	}
+	return tw.Flush()
+}
+
+// formatInterval formats i with format as the mean and the half width as a
+// percentage of it.
+func formatInterval(i Interval, format func(float64) string) string {
+	if math.IsInf(i.HalfWidth, 1) {
+		return format(i.Mean) + " ± ?"
+	}
+	return fmt.Sprintf("%s ± %.1f%%", format(i.Mean), i.Relative()*100)
+}
+
+// formatNs formats nanoseconds as a duration.
+func formatNs(ns float64) string {
+	return time.Duration(ns).String()
+}
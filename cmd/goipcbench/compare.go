@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jackc/goipcbench/bench"
+)
+
+// compare runs "goipcbench compare", which compares new results against a
+// baseline and exits with status 1 if any case regressed.
+func compare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: goipcbench compare [flags] baseline new\n\n")
+		fmt.Fprintf(flags.Output(), "Each file is a -json report or the output of go test -bench with -count.\n\n")
+		flags.PrintDefaults()
+	}
+	metric := flags.String("metric", "", "metric to compare: a report field such as p99_ns, or a go test unit such as ns/op (default mean latency)")
+	alpha := flags.Float64("alpha", 0.05, "significance level of the Mann-Whitney U test")
+	threshold := flags.Float64("threshold", 0.05, "relative slowdown beyond which a significant change is a regression")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	old, err := readSamples(flags.Arg(0), *metric)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		os.Exit(2)
+	}
+	latest, err := readSamples(flags.Arg(1), *metric)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		os.Exit(2)
+	}
+
+	comparisons := bench.Compare(old, latest)
+	if len(comparisons) == 0 {
+		fmt.Fprintf(os.Stderr, "goipcbench: no cases in common between %s and %s\n", flags.Arg(0), flags.Arg(1))
+		os.Exit(2)
+	}
+	regressions, err := bench.WriteComparisons(os.Stdout, comparisons, *alpha, *threshold)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		os.Exit(2)
+	}
+	if regressions > 0 {
+		fmt.Fprintf(os.Stderr, "goipcbench: %d regressions beyond %.0f%%\n", regressions, *threshold*100)
+		os.Exit(1)
+	}
+}
+
+// readSamples reads metric from the results file at path.
+func readSamples(path, metric string) (bench.Samples, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := bench.ReadSamples(data, metric)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return samples, nil
+}
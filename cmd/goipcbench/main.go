@@ -0,0 +1,304 @@
+// Command goipcbench measures calls from a host process into plugin
+// processes over each transport and prints a comparison table.
+//
+// "goipcbench compare baseline new" compares results against a baseline and
+// exits with status 1 if any case regressed.
+//
+// The plugins are built into the same binary, which runs them when started
+// as "goipcbench plugin <transport> <args>", so it can be deployed on its own.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/goipcbench/affinity"
+	"github.com/jackc/goipcbench/bench"
+	"github.com/jackc/goipcbench/plugins/mmap"
+	"github.com/jackc/goipcbench/plugins/stdio"
+	"github.com/jackc/goipcbench/plugins/tcp"
+	"github.com/jackc/goipcbench/plugins/unix"
+	"github.com/jackc/goipcbench/profile"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// plugins maps each transport to the plugin that serves it.
+var plugins = map[string]func(args []string){
+	"stdio": stdio.Main,
+	"tcp":   tcp.Main,
+	"unix":  unix.Main,
+	"mmap":  mmap.Main,
+}
+
+func main() {
+	if len(os.Args) > 2 && os.Args[1] == "plugin" {
+		runPlugin(os.Args[2], os.Args[3:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compare(os.Args[2:])
+		return
+	}
+	os.Exit(run())
+}
+
+// run runs the benchmarks the flags describe and returns the exit status,
+// once the deferred profiling has stopped.
+func run() int {
+	transports := flag.String("transports", strings.Join(bench.Transports, ","), "comma separated transports to run")
+	sizes := flag.String("sizes", "0", "comma separated payload sizes in bytes")
+	concurrency := flag.String("concurrency", "1", "comma separated numbers of plugins called at once")
+	waits := flag.String("wait", "spin", "comma separated ways the host waits on shared memory: spin, yield or sleep")
+	rates := flag.String("rate", "0", "comma separated total calls per second to schedule, measuring latency from the scheduled time; 0 calls again on each reply")
+	sweep := flag.Bool("sweep", false, "raise the rate of each case from -sweep-start by -sweep-factor until it saturates")
+	sweepStart := flag.Float64("sweep-start", 1000, "first `rate` of -sweep")
+	sweepFactor := flag.Float64("sweep-factor", 1.5, "multiply each -sweep rate by `factor` for the next")
+	sweepMax := flag.Float64("sweep-max", 0, "highest `rate` of -sweep, 0 for no limit")
+	slo := flag.Duration("slo", 0, "p99 latency above which a -sweep rate counts as saturated")
+	warmup := flag.Duration("warmup", 100*time.Millisecond, "how long to call each plugin before measuring")
+	duration := flag.Duration("duration", time.Second, "how long to run each case")
+	calls := flag.Int("calls", 0, "call each plugin `n` times instead of for -duration")
+	count := flag.Int("count", 1, "run each case in `n` independent trials, for confidence intervals and goipcbench compare")
+	confidence := flag.Float64("confidence", 0.95, "confidence `level` of the intervals over trials")
+	hostCPUs := flag.String("host-cpus", "", "pin the calling threads to the CPUs in `list`, such as 0-3,8")
+	pluginCPUs := flag.String("plugin-cpus", "", "pin the plugin processes to the CPUs in `list`")
+	placement := flag.String("placement", "", "pin the host and plugins to one CPU each at a `placement`: "+strings.Join(affinity.Placements, ", "))
+	jsonPath := flag.String("json", "", "write results as JSON to `file`")
+	csvPath := flag.String("csv", "", "write results as CSV to `file`")
+	profileDir := flag.String("profile", "", "write CPU, block and mutex profiles and traces of the host and plugins to `dir`")
+	flag.Parse()
+
+	if *sweep && *rates != "0" {
+		// Each rate would be a separate case swept from -sweep-start
+		fmt.Fprintln(os.Stderr, "goipcbench: -sweep sets the rate; use -sweep-start instead of -rate")
+		return 2
+	}
+	cases, err := expand(*transports, *sizes, *concurrency, *waits, *rates)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		return 2
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		fmt.Fprintf(os.Stderr, "goipcbench: -confidence %v is not between 0 and 1\n", *confidence)
+		return 2
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: find own executable: %v\n", err)
+		return 1
+	}
+	opts := bench.Options{
+		Plugin: func(transport string) (string, []string) {
+			return self, []string{"plugin", transport}
+		},
+		Warmup:     *warmup,
+		Duration:   *duration,
+		Calls:      *calls,
+		Stderr:     os.Stderr,
+		ProfileDir: *profileDir,
+	}
+	if err := pin(&opts, *hostCPUs, *pluginCPUs, *placement); err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *profileDir != "" {
+		stopProfile, err := profile.Start(*profileDir, "host")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := stopProfile(); err != nil {
+				fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+			}
+		}()
+	}
+
+	env := bench.CaptureEnv()
+	var results []*bench.Result
+	for _, c := range cases {
+		for range *count {
+			if *sweep {
+				fmt.Fprintf(os.Stderr, "sweeping %v\n", c)
+				rs, err := bench.Sweep(ctx, c, opts, bench.SweepOptions{Start: *sweepStart, Factor: *sweepFactor, Max: *sweepMax, SLO: *slo})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+					return 1
+				}
+				results = append(results, rs...)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "running %v\n", c)
+			r, err := bench.Run(ctx, c, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+				return 1
+			}
+			results = append(results, r)
+		}
+	}
+
+	bench.WriteConfig(os.Stdout, env)
+	if err := bench.WriteTable(os.Stdout, results); err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		return 1
+	}
+	if *sweep {
+		fmt.Println()
+		if err := bench.WriteCapacities(os.Stdout, bench.Capacities(results, *slo), *slo); err != nil {
+			fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+			return 1
+		}
+	}
+	if *count > 1 {
+		fmt.Println()
+		if err := bench.WriteSummaries(os.Stdout, bench.Summarize(results, *confidence)); err != nil {
+			fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+			return 1
+		}
+	}
+
+	report := bench.NewReport(env, results)
+	if err := writeFile(*jsonPath, report, bench.WriteJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		return 1
+	}
+	if err := writeFile(*csvPath, report, bench.WriteCSV); err != nil {
+		fmt.Fprintf(os.Stderr, "goipcbench: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeFile writes report to path with write. An empty path writes nothing
+// and "-" writes to standard output.
+func writeFile(path string, report *bench.Report, write func(io.Writer, *bench.Report) error) error {
+	if path == "" {
+		return nil
+	}
+	if path == "-" {
+		return write(os.Stdout, report)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, report); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// pin sets the CPUs in opts from the CPU list flags, or from placement if
+// it is set.
+func pin(opts *bench.Options, hostCPUs, pluginCPUs, placement string) error {
+	if placement != "" {
+		cpus, err := affinity.Topology()
+		if err != nil {
+			return fmt.Errorf("-placement: %w", err)
+		}
+		p, ok := affinity.Place(cpus, placement)
+		if !ok {
+			return fmt.Errorf("-placement: no %s pair among the CPUs this process may use", placement)
+		}
+		opts.HostCPUs, opts.PluginCPUs = []int{p.Host}, []int{p.Plugin}
+		return nil
+	}
+
+	var err error
+	if opts.HostCPUs, err = affinity.ParseList(hostCPUs); err != nil {
+		return fmt.Errorf("-host-cpus: %w", err)
+	}
+	if opts.PluginCPUs, err = affinity.ParseList(pluginCPUs); err != nil {
+		return fmt.Errorf("-plugin-cpus: %w", err)
+	}
+	return nil
+}
+
+// runPlugin runs the plugin for transport with its transport arguments.
+func runPlugin(transport string, args []string) {
+	run, ok := plugins[transport]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "goipcbench: unknown plugin %q\n", transport)
+		os.Exit(2)
+	}
+	run(args)
+}
+
+// expand returns every combination of the comma separated flag values. Wait
+// strategies only multiply the mmap cases.
+func expand(transports, sizes, concurrency, waits, rates string) ([]bench.Case, error) {
+	sizeList, err := ints(sizes)
+	if err != nil {
+		return nil, fmt.Errorf("-sizes: %w", err)
+	}
+	concurrencyList, err := ints(concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("-concurrency: %w", err)
+	}
+	var rateList []float64
+	for _, s := range strings.Split(rates, ",") {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("-rate: %w", err)
+		}
+		rateList = append(rateList, rate)
+	}
+	var waitList []protocol.WaitStrategy
+	for _, s := range strings.Split(waits, ",") {
+		w, err := protocol.ParseWaitStrategy(s)
+		if err != nil {
+			return nil, fmt.Errorf("-wait: %w", err)
+		}
+		waitList = append(waitList, w)
+	}
+
+	var cases []bench.Case
+	for _, transport := range strings.Split(transports, ",") {
+		transportWaits := waitList
+		if transport != "mmap" {
+			transportWaits = waitList[:1]
+		}
+		for _, size := range sizeList {
+			for _, conc := range concurrencyList {
+				for _, wait := range transportWaits {
+					for _, rate := range rateList {
+						c := bench.Case{Transport: transport, Wait: wait, Size: size, Concurrency: conc, Rate: rate}
+						if err := c.Validate(); err != nil {
+							return nil, err
+						}
+						cases = append(cases, c)
+					}
+				}
+			}
+		}
+	}
+	return cases, nil
+}
+
+// ints parses a comma separated list of integers.
+func ints(s string) ([]int, error) {
+	var list []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
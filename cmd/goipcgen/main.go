@@ -0,0 +1,52 @@
+// Command goipcgen generates typed host stubs and plugin skeletons for Go
+// interfaces, as described in package ipcgen. It is meant to be run by go
+// generate from the package declaring the interfaces:
+//
+//	//go:generate go run github.com/jackc/goipcbench/cmd/goipcgen -type KV
+//
+// The code is written to <type>_ipc.go, after the first type in lower case,
+// unless -o is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/goipcbench/ipcgen"
+)
+
+func main() {
+	types := flag.String("type", "", "comma separated names of the interfaces to generate code for")
+	output := flag.String("o", "", "write the generated code to `file`")
+	dir := flag.String("dir", ".", "`directory` of the package declaring the interfaces")
+	flag.Parse()
+	if *types == "" {
+		fmt.Fprintln(os.Stderr, "goipcgen: -type is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	names := strings.Split(*types, ",")
+	pkg, err := ipcgen.Parse(*dir, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcgen: %v\n", err)
+		os.Exit(1)
+	}
+	src, err := ipcgen.Generate(pkg, "goipcgen -type "+*types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goipcgen: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *output
+	if path == "" {
+		path = filepath.Join(*dir, strings.ToLower(names[0])+"_ipc.go")
+	}
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "goipcgen: %v\n", err)
+		os.Exit(1)
+	}
+}
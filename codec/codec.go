@@ -0,0 +1,150 @@
+// Package codec encodes the payloads of calls to plugins, so the cost of
+// serialization can be measured apart from, and together with, the cost of
+// the transport.
+//
+// Gob and JSON encode any value their packages can. Binary and Flat are
+// written by hand for each type: Binary is a compact sequential encoding
+// that is decoded into Go values, and Flat is a layout of fixed offsets that
+// is read in place, without decoding, through a view of the encoded bytes.
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes and decodes values.
+type Codec interface {
+	// Name identifies the codec, as in "gob".
+	Name() string
+
+	// Append appends the encoding of v to buf.
+	Append(buf []byte, v any) ([]byte, error)
+
+	// Unmarshal decodes data into v, which must be a pointer.
+	Unmarshal(data []byte, v any) error
+}
+
+// BinaryDecoder is implemented by types that decode the encoding appended by
+// their encoding.BinaryAppender. It is not encoding.BinaryUnmarshaler, which
+// gob would use in place of its own encoding.
+type BinaryDecoder interface {
+	DecodeBinary(data []byte) error
+}
+
+// FlatAppender is implemented by types with a Flat encoding.
+type FlatAppender interface {
+	AppendFlat(buf []byte) ([]byte, error)
+}
+
+// FlatUnmarshaler is implemented by types that decode, or view, a Flat
+// encoding. Unlike the other codecs, UnmarshalFlat may retain data.
+type FlatUnmarshaler interface {
+	UnmarshalFlat(data []byte) error
+}
+
+var (
+	// Gob encodes with encoding/gob. Each encoding starts a new gob stream
+	// so it carries a description of its type, as a payload decoded on its
+	// own must.
+	Gob Codec = gobCodec{}
+
+	// JSON encodes with encoding/json.
+	JSON Codec = jsonCodec{}
+
+	// Binary encodes values that implement encoding.BinaryAppender and
+	// decodes into ones that implement BinaryDecoder.
+	Binary Codec = binaryCodec{}
+
+	// Flat encodes values that implement FlatAppender and decodes into ones
+	// that implement FlatUnmarshaler.
+	Flat Codec = flatCodec{}
+)
+
+// All are the codecs in this package.
+var All = []Codec{Gob, JSON, Binary, Flat}
+
+// Lookup returns the codec in All with name.
+func Lookup(name string) (Codec, error) {
+	for _, c := range All {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Append(buf []byte, v any) ([]byte, error) {
+	w := bytes.NewBuffer(buf)
+	if err := gob.NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Append(buf []byte, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, data...), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Append(buf []byte, v any) ([]byte, error) {
+	a, ok := v.(encoding.BinaryAppender)
+	if !ok {
+		return nil, fmt.Errorf("binary codec: %T does not implement encoding.BinaryAppender", v)
+	}
+	return a.AppendBinary(buf)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) error {
+	d, ok := v.(BinaryDecoder)
+	if !ok {
+		return fmt.Errorf("binary codec: %T does not implement codec.BinaryDecoder", v)
+	}
+	return d.DecodeBinary(data)
+}
+
+type flatCodec struct{}
+
+func (flatCodec) Name() string { return "flat" }
+
+func (flatCodec) Append(buf []byte, v any) ([]byte, error) {
+	a, ok := v.(FlatAppender)
+	if !ok {
+		return nil, fmt.Errorf("flat codec: %T does not implement codec.FlatAppender", v)
+	}
+	return a.AppendFlat(buf)
+}
+
+func (flatCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(FlatUnmarshaler)
+	if !ok {
+		return fmt.Errorf("flat codec: %T does not implement codec.FlatUnmarshaler", v)
+	}
+	return u.UnmarshalFlat(data)
+}
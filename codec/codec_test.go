@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{Gob, JSON} {
+		data, err := c.Append([]byte("prefix"), point{3, 4})
+		if err != nil {
+			t.Fatalf("%s: Append: %v", c.Name(), err)
+		}
+		if string(data[:6]) != "prefix" {
+			t.Errorf("%s: Append did not append to buf", c.Name())
+		}
+		var got point
+		if err := c.Unmarshal(data[6:], &got); err != nil || !reflect.DeepEqual(got, point{3, 4}) {
+			t.Errorf("%s: Unmarshal: got %+v, %v", c.Name(), got, err)
+		}
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	for _, c := range []Codec{Binary, Flat} {
+		if _, err := c.Append(nil, point{}); err == nil {
+			t.Errorf("%s: Append succeeded with a type it cannot encode", c.Name())
+		}
+		if err := c.Unmarshal(nil, &point{}); err == nil {
+			t.Errorf("%s: Unmarshal succeeded with a type it cannot decode", c.Name())
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, c := range All {
+		if got, err := Lookup(c.Name()); err != nil || got != c {
+			t.Errorf("Lookup(%q): got %v, %v", c.Name(), got, err)
+		}
+	}
+	if _, err := Lookup("xml"); err == nil {
+		t.Errorf("Lookup of unknown codec succeeded")
+	}
+}
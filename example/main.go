@@ -0,0 +1,13 @@
+// Command example is a plugin written with package plugin. It serves
+// whichever transport the host starts it with.
+package main
+
+import (
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/plugins/demo"
+)
+
+func main() {
+	demo.Register(plugin.DefaultServer)
+	plugin.Serve()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// TestExample launches the example plugin, which serves whichever transport
+// it is started with, over each transport.
+func TestExample(t *testing.T) {
+	for _, tt := range []struct {
+		transport  string
+		maxPayload uint32
+	}{
+		{"stdio", protocol.MaxStreamPayload},
+		{"tcp", protocol.MaxStreamPayload},
+		{"unix", protocol.MaxStreamPayload},
+		{"mmap", protocol.MaxMmapPayload},
+	} {
+		t.Run(tt.transport, func(t *testing.T) {
+			p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Transport: tt.transport})
+			testHandshake(t, p, "example-plugin", tt.maxPayload)
+			testCallback(t, p)
+			testStream(t, p)
+			testErrors(t, p)
+			testShutdown(t, p)
+		})
+	}
+}
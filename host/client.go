@@ -0,0 +1,284 @@
+// Package host calls into plugins from the host process.
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// cancelTimeout bounds how long sending a cancel may wait, since the call it
+// cancels has already failed.
+const cancelTimeout = 100 * time.Millisecond
+
+// aLongTimeAgo is a deadline in the past that makes blocked I/O return.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// Handler serves requests the plugin makes while a call is in progress.
+	Handler protocol.Handler
+
+	// SendCancel makes Call tell the plugin when it gives up on a request, so
+	// a plugin waiting on a callback for it stops instead of finishing the
+	// work. It has no effect if the plugin's hello, when there is one, does
+	// not list TypeCancel.
+	SendCancel bool
+
+	// StreamWindow is how many chunks of a streamed reply the plugin may
+	// send ahead of Recv. If zero, DefaultStreamWindow is used.
+	StreamWindow uint32
+}
+
+// DefaultStreamWindow is the StreamWindow used if none is set.
+const DefaultStreamWindow = 16
+
+// Client makes calls to a plugin over a protocol.Conn. A Client is not safe
+// for concurrent use.
+type Client struct {
+	conn   protocol.Conn
+	opts   ClientOptions
+	hello  *protocol.Hello
+	nextID uint32
+
+	// hasDeadline records whether a deadline may be set on conn, so calls
+	// without one need not clear it.
+	hasDeadline bool
+
+	// abandoned is the ID of a request Call gave up on whose reply has not
+	// arrived, or zero. canceled records whether the plugin was told.
+	abandoned uint32
+	canceled  bool
+
+	// stream is the stream whose reply is being received, or nil.
+	stream *Stream
+}
+
+// NewClient returns a Client that calls the plugin on the other end of conn.
+func NewClient(conn protocol.Conn, opts ClientOptions) *Client {
+	return &Client{conn: conn, opts: opts}
+}
+
+// Conn returns the connection to the plugin.
+func (c *Client) Conn() protocol.Conn {
+	return c.conn
+}
+
+// Hello returns the plugin's hello, or nil before Handshake.
+func (c *Client) Hello() *protocol.Hello {
+	return c.hello
+}
+
+// Handshake exchanges hellos with the plugin and returns the plugin's.
+func (c *Client) Handshake(ctx context.Context, hello *protocol.Hello) (*protocol.Hello, error) {
+	stop, err := c.watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	plugin, err := protocol.HandshakeHost(c.conn, hello)
+	if err != nil {
+		return nil, c.ctxErr(ctx, "handshake", err)
+	}
+	c.hello = plugin
+	return plugin, nil
+}
+
+// Call sends a request for method to the plugin and returns the response
+// payload. It gives up when ctx is done. An error reply from the plugin is
+// returned as a *protocol.Error.
+//
+// A reply to a call that was given up on is waited for and discarded at the
+// start of the next call.
+func (c *Client) Call(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	resp, err := c.send(ctx, method, &protocol.Message{Type: protocol.TypeRequest, Method: method, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+// Shutdown asks the plugin to stop taking requests, release its resources
+// and exit, and waits for it to acknowledge. The plugin may still be running
+// when Shutdown returns.
+func (c *Client) Shutdown(ctx context.Context) error {
+	if c.hello != nil && !slices.Contains(c.hello.Types, protocol.TypeShutdown) {
+		return fmt.Errorf("plugin %q does not support shutdown", c.hello.Name)
+	}
+	_, err := c.send(ctx, "shutdown", &protocol.Message{Type: protocol.TypeShutdown})
+	return err
+}
+
+// send sends msg with the next request ID and waits for the reply. op names
+// msg in errors.
+func (c *Client) send(ctx context.Context, op string, msg *protocol.Message) (*protocol.Message, error) {
+	stop, err := c.watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	if err := c.settle(); err != nil {
+		return nil, c.ctxErr(ctx, op, err)
+	}
+
+	c.nextID++
+	msg.ID = c.nextID
+	if err := c.conn.WriteMessage(msg); err != nil {
+		return nil, c.ctxErr(ctx, op, err)
+	}
+
+	resp, err := c.wait(msg.ID, false)
+	if err != nil {
+		err = c.ctxErr(ctx, op, err)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			c.abandon(msg.ID, false)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// settle closes a stream that is still open and waits for the reply to a
+// request that was given up on, so the next request can be sent.
+func (c *Client) settle() error {
+	if c.stream != nil {
+		c.stream.Close()
+	}
+	if c.abandoned != 0 {
+		if _, err := c.wait(c.abandoned, c.canceled); err != nil && !isReply(err) {
+			return err
+		}
+		c.abandoned = 0
+	}
+	return nil
+}
+
+// wait reads messages until the reply to the request with id arrives,
+// serving the plugin's requests in the meantime and dropping any chunks of a
+// stream with id. If drop is set, requests the plugin makes on behalf of id
+// are dropped rather than served since the plugin has been told id is
+// canceled.
+func (c *Client) wait(id uint32, drop bool) (*protocol.Message, error) {
+	for {
+		msg, err := c.next(id, drop)
+		if err != nil || msg.Type != protocol.TypeChunk {
+			return msg, err
+		}
+	}
+}
+
+// next reads messages until a chunk of the stream with id or the reply to
+// the request with id arrives, serving the plugin's requests in the
+// meantime as wait does. An error reply is returned as the error.
+func (c *Client) next(id uint32, drop bool) (*protocol.Message, error) {
+	for {
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case protocol.TypeResponse, protocol.TypeChunk:
+			if msg.ID == id {
+				return msg, nil
+			}
+			return nil, fmt.Errorf("%v ID %d does not match request ID %d", msg.Type, msg.ID, id)
+		case protocol.TypeError:
+			// A plugin that could not decode the request cannot know its ID
+			if msg.ID == id || msg.ID == 0 {
+				return nil, msg.Err()
+			}
+			return nil, fmt.Errorf("error ID %d does not match request ID %d", msg.ID, id)
+		case protocol.TypeRequest:
+			if drop && msg.ID == id {
+				continue
+			}
+			if err := c.conn.WriteMessage(protocol.Answer(msg, c.opts.Handler)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %v message", msg.Type)
+		}
+	}
+}
+
+// abandon records that Call gave up on the request with id and tells the
+// plugin if configured to, or if cancel is set.
+func (c *Client) abandon(id uint32, cancel bool) {
+	c.abandoned = id
+	c.canceled = false
+	if !(c.opts.SendCancel || cancel) || (c.hello != nil && !slices.Contains(c.hello.Types, protocol.TypeCancel)) {
+		return
+	}
+
+	c.conn.SetDeadline(time.Now().Add(cancelTimeout))
+	c.hasDeadline = true
+	if err := c.conn.WriteMessage(&protocol.Message{Type: protocol.TypeCancel, ID: id}); err == nil {
+		c.canceled = true
+	}
+}
+
+// watch applies ctx's deadline and cancellation to the connection. The
+// returned function must be called once the I/O for ctx is done.
+func (c *Client) watch(ctx context.Context) (func(), error) {
+	if ctx.Done() == nil {
+		// Calls with a background context pay nothing for deadlines
+		if c.hasDeadline {
+			if err := c.conn.SetDeadline(time.Time{}); err != nil {
+				return nil, err
+			}
+			c.hasDeadline = false
+		}
+		return func() {}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	c.hasDeadline = true
+
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(aLongTimeAgo)
+		close(done)
+	})
+	return func() {
+		if !stop() {
+			<-done
+		}
+	}, nil
+}
+
+// ctxErr returns the error for a failed call, preferring ctx's error when it
+// caused the failure.
+func (c *Client) ctxErr(ctx context.Context, op string, err error) error {
+	if isReply(err) {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+	// The connection's deadline can pass just before ctx notices
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%s: %w", op, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// isReply reports whether err is an error reply from the plugin rather than
+// a failure of the connection.
+func isReply(err error) bool {
+	_, ok := err.(*protocol.Error)
+	return ok
+}
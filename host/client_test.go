@@ -0,0 +1,129 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// socketPair returns connected stream conns for the host and the plugin. A
+// real socket is used rather than net.Pipe since writes must not block on the
+// reader.
+func socketPair(t *testing.T) (hostConn, pluginConn protocol.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	a, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	b, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return protocol.NewStreamConn(a, a), protocol.NewStreamConn(b, b)
+}
+
+// mmapPair returns mmap conns for the host and the plugin sharing a region.
+func mmapPair() (hostConn, pluginConn protocol.Conn) {
+	data := make([]byte, protocol.MmapSize)
+	return protocol.NewMmapConn(data, protocol.SideHost, protocol.WaitSpin), protocol.NewMmapConn(data, protocol.SidePlugin, protocol.WaitYield)
+}
+
+func testHungPlugin(t *testing.T, hostConn protocol.Conn) {
+	client := NewClient(hostConn, ClientOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Call(ctx, "ping", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call: got %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Call took %v", elapsed)
+	}
+}
+
+func TestCallStreamHungPlugin(t *testing.T) {
+	hostConn, _ := socketPair(t)
+	testHungPlugin(t, hostConn)
+}
+
+func TestCallMmapHungPlugin(t *testing.T) {
+	hostConn, _ := mmapPair()
+	testHungPlugin(t, hostConn)
+}
+
+// testCancelDuringCallback gives up on a request that the plugin then calls
+// back into the host for, and checks the plugin learns of the cancel and the
+// client recovers.
+func testCancelDuringCallback(t *testing.T, hostConn, pluginConn protocol.Conn) {
+	callbackErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := pluginConn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch req.Method {
+			case "lookup":
+				// Call back only once the host has given up
+				time.Sleep(100 * time.Millisecond)
+				_, err := protocol.Call(pluginConn, &protocol.Message{Type: protocol.TypeRequest, ID: req.ID, Method: "kv.get"}, nil)
+				callbackErr <- err
+				pluginConn.WriteMessage(protocol.ErrorMessage(req.ID, err))
+			case "ping":
+				pluginConn.WriteMessage(&protocol.Message{Type: protocol.TypeResponse, ID: req.ID, Payload: []byte("pong")})
+			}
+		}
+	}()
+
+	handler := func(method string, payload []byte) ([]byte, error) {
+		t.Errorf("Callback for canceled request was served")
+		return nil, nil
+	}
+	client := NewClient(hostConn, ClientOptions{Handler: handler, SendCancel: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(ctx, "lookup", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call: got %v, want deadline exceeded", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err := client.Call(ctx, "ping", nil)
+	if err != nil {
+		t.Fatalf("Call after cancel: %v", err)
+	}
+	if string(response) != "pong" {
+		t.Fatalf("Call after cancel: got %q, want pong", response)
+	}
+
+	var perr *protocol.Error
+	if err := <-callbackErr; !errors.As(err, &perr) || perr.Code != protocol.CodeCanceled {
+		t.Fatalf("Plugin callback: got %v, want CodeCanceled error", err)
+	}
+}
+
+func TestCallStreamCancelDuringCallback(t *testing.T) {
+	hostConn, pluginConn := socketPair(t)
+	testCancelDuringCallback(t, hostConn, pluginConn)
+}
+
+func TestCallMmapCancelDuringCallback(t *testing.T) {
+	hostConn, pluginConn := mmapPair()
+	testCancelDuringCallback(t, hostConn, pluginConn)
+}
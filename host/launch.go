@@ -0,0 +1,380 @@
+package host
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/jackc/goipcbench/affinity"
+	"github.com/jackc/goipcbench/peer"
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/profile"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// LaunchOptions configures how a plugin process is started.
+type LaunchOptions struct {
+	// Transport is how Launch talks to the plugin: "stdio", "tcp", "unix"
+	// or "mmap". If empty it is stdio. The Start functions ignore it.
+	Transport string
+
+	// Client configures the client connected to the plugin.
+	Client ClientOptions
+
+	// Hello is what the host introduces itself with. Its MaxPayload is
+	// lowered to what the transport allows. If nil, a hello named
+	// "goipcbench" with no methods is used.
+	Hello *protocol.Hello
+
+	// Args are passed to the plugin ahead of the transport's own arguments,
+	// for a binary that holds more than one plugin.
+	Args []string
+
+	// Stderr receives the plugin's standard error. If nil it is discarded.
+	Stderr io.Writer
+
+	// Wait is how the host waits on the shared memory region. It only
+	// applies to the mmap transport.
+	Wait protocol.WaitStrategy
+
+	// CPUs pins the plugin process to these CPUs. If empty the plugin runs
+	// wherever the host may.
+	CPUs []int
+
+	// Limits bound the resources the plugin process may use. A plugin
+	// killed for exceeding them fails calls with an ExitError saying so.
+	// They are only supported on Linux.
+	Limits Limits
+
+	// Sandbox has the plugin sandbox itself once its transport is
+	// connected, as described in package sandbox. A plugin that cannot
+	// fails to start rather than running without it. A sandboxed plugin
+	// must be built without cgo and cannot be profiled.
+	Sandbox bool
+
+	// ProfileDir makes the plugin write CPU, block and mutex profiles and
+	// an execution trace into this directory when it shuts down, as
+	// described in package profile. A plugin that is killed writes none.
+	ProfileDir string
+}
+
+// hello returns the hello to send over a transport carrying at most
+// maxPayload.
+func (o *LaunchOptions) hello(maxPayload uint32) *protocol.Hello {
+	if o.Hello == nil {
+		return protocol.NewHello("goipcbench", maxPayload)
+	}
+	hello := *o.Hello
+	if hello.MaxPayload == 0 || hello.MaxPayload > maxPayload {
+		hello.MaxPayload = maxPayload
+	}
+	return &hello
+}
+
+// command returns the command that runs the plugin at path over transport
+// with the transport arguments args. The transport is also named in the
+// environment for plugins that serve more than one.
+func (o *LaunchOptions) command(path, transport string, args ...string) *exec.Cmd {
+	cmd := exec.Command(path, append(slices.Clip(o.Args), args...)...)
+	cmd.Env = append(os.Environ(), plugin.EnvTransport+"="+transport)
+	if o.ProfileDir != "" {
+		cmd.Env = append(cmd.Env, profile.EnvDir+"="+o.ProfileDir)
+	}
+	if o.Sandbox {
+		cmd.Env = append(cmd.Env, plugin.EnvSandbox+"=1")
+	}
+	return cmd
+}
+
+// start starts cmd with the limits in o, pinned to the CPUs in o. It
+// returns what tells which limit the process was killed for exceeding,
+// which is nil if it has none.
+func (o *LaunchOptions) start(cmd *exec.Cmd) (exceeded func(*os.ProcessState) string, err error) {
+	if o.Sandbox && o.ProfileDir != "" {
+		return nil, errors.New("a sandboxed plugin cannot write profiles")
+	}
+	if len(o.CPUs) > 0 {
+		// The child inherits the affinity of the thread that forks it, so
+		// the plugin runs on the CPUs from its first instruction
+		unlock, err := affinity.LockThread(o.CPUs)
+		if err != nil {
+			return nil, fmt.Errorf("set CPU affinity: %w", err)
+		}
+		defer unlock()
+	}
+	if o.Limits != (Limits{}) {
+		if err := startLimited(cmd, o.Limits); err != nil {
+			return nil, err
+		}
+		exceeded = o.Limits.exceeded
+	} else if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return exceeded, nil
+}
+
+// Transports are the transports Launch supports.
+var Transports = []string{"stdio", "tcp", "unix", "mmap"}
+
+// starts maps each of Transports to the function that starts a plugin on
+// it.
+var starts = map[string]func(context.Context, string, LaunchOptions) (*Plugin, error){
+	"stdio": StartStdio,
+	"tcp":   StartTCP,
+	"unix":  StartUnix,
+	"mmap":  StartMmap,
+}
+
+// Launch starts the plugin at path, connects to it over opts.Transport and
+// returns it once it has completed the handshake. The plugin must serve
+// that transport, as plugins written with package plugin do whichever it
+// is. ctx bounds starting the plugin, not its life; Close the plugin to
+// shut it down.
+func Launch(ctx context.Context, path string, opts LaunchOptions) (*Plugin, error) {
+	transport := opts.Transport
+	if transport == "" {
+		transport = "stdio"
+	}
+	start, ok := starts[transport]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", transport)
+	}
+	return start(ctx, path, opts)
+}
+
+// StartStdio starts the stdio plugin at path and talks to it over its
+// standard input and output.
+func StartStdio(ctx context.Context, path string, opts LaunchOptions) (*Plugin, error) {
+	// The pipes are made by hand, unlike cmd.StdinPipe and cmd.StdoutPipe, so
+	// they support deadlines and Wait does not close them under a reader
+	stdinRead, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create stdin pipe: %w", err)
+	}
+	stdout, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		stdinRead.Close()
+		stdin.Close()
+		return nil, fmt.Errorf("create stdout pipe: %w", err)
+	}
+
+	cmd := opts.command(path, "stdio")
+	cmd.Stdin = stdinRead
+	cmd.Stdout = stdoutWrite
+	cmd.Stderr = opts.Stderr
+	exceeded, err := opts.start(cmd)
+	stdinRead.Close()
+	stdoutWrite.Close()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+
+	conn := protocol.NewStreamConn(stdout, stdin)
+	return newPlugin(ctx, cmd, exceeded, conn, opts.hello(protocol.MaxStreamPayload), opts.Client)
+}
+
+// StartTCP starts the TCP plugin at path on a free localhost port and
+// connects to it. The plugin is handed a one-time token on an inherited
+// pipe, which the host sends first on the connection so that the plugin
+// serves no one else.
+func StartTCP(ctx context.Context, path string, opts LaunchOptions) (*Plugin, error) {
+	// Find available port
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("find available port: %w", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// The token fits in the pipe's buffer, so it is written before the
+	// plugin starts
+	token := peer.NewToken()
+	tokenRead, tokenWrite, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create token pipe: %w", err)
+	}
+	defer tokenRead.Close()
+	_, err = tokenWrite.Write(token)
+	tokenWrite.Close()
+	if err != nil {
+		return nil, fmt.Errorf("write token: %w", err)
+	}
+
+	cmd := opts.command(path, "tcp", fmt.Sprint(port))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, tokenRead)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", plugin.EnvTokenFD, 2+len(cmd.ExtraFiles)))
+	addr := fmt.Sprintf("localhost:%d", port)
+	return startSocket(ctx, cmd, "tcp", addr, opts, func(conn net.Conn) error {
+		_, err := conn.Write(token)
+		return err
+	})
+}
+
+// StartUnix starts the Unix domain socket plugin at path listening in a new
+// temporary directory and connects to it, checking that the process
+// listening is the plugin. The plugin in turn only accepts the host.
+func StartUnix(ctx context.Context, path string, opts LaunchOptions) (*Plugin, error) {
+	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp directory: %w", err)
+	}
+	socketPath := filepath.Join(tmpDir, "plugin.sock")
+
+	cmd := opts.command(path, "unix", socketPath)
+	p, err := startSocket(ctx, cmd, "unix", socketPath, opts, func(conn net.Conn) error {
+		return checkPeer(conn, cmd.Process.Pid)
+	}, func() { os.RemoveAll(tmpDir) })
+	if err != nil {
+		os.RemoveAll(tmpDir)
+	}
+	return p, err
+}
+
+// checkPeer returns an error unless the other end of conn is pid, where the
+// system can tell.
+func checkPeer(conn net.Conn, pid int) error {
+	if err := peer.Check(conn, pid); err != nil && !errors.Is(err, peer.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// startSocket starts cmd, waits for it to print "ready" once it is listening,
+// connects to addr and authenticates the connection.
+func startSocket(ctx context.Context, cmd *exec.Cmd, network, addr string, opts LaunchOptions, authenticate func(net.Conn) error, release ...func()) (*Plugin, error) {
+	stdout, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create stdout pipe: %w", err)
+	}
+	defer stdout.Close()
+
+	cmd.Stdout = stdoutWrite
+	cmd.Stderr = opts.Stderr
+	exceeded, err := opts.start(cmd)
+	stdoutWrite.Close()
+	if err != nil {
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+
+	// Wait for plugin to be ready
+	ctx, cancel := withStartTimeout(ctx)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	stdout.SetReadDeadline(deadline)
+	scanner := bufio.NewScanner(stdout)
+	if scanned := scanner.Scan(); !scanned || scanner.Text() != "ready" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, notReady(scanner, scanned)
+	}
+
+	// Connect to plugin
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("connect to plugin: %w", err)
+	}
+	if err := authenticate(netConn); err != nil {
+		netConn.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("authenticate plugin connection: %w", err)
+	}
+
+	conn := protocol.NewStreamConn(netConn, netConn)
+	return newPlugin(ctx, cmd, exceeded, conn, opts.hello(protocol.MaxStreamPayload), opts.Client, release...)
+}
+
+// notReady returns why scanner, reading a plugin's standard output, did not
+// read the ready signal. scanned is whether it read a line.
+func notReady(scanner *bufio.Scanner, scanned bool) error {
+	switch {
+	case scanned:
+		return fmt.Errorf("plugin printed %q rather than signal ready", scanner.Text())
+	case scanner.Err() != nil:
+		return fmt.Errorf("plugin did not signal ready: %w", scanner.Err())
+	default:
+		return errors.New("plugin exited before it was ready")
+	}
+}
+
+// StartMmap starts the shared memory plugin at path with a region backed by
+// a file in a new temporary directory.
+func StartMmap(ctx context.Context, path string, opts LaunchOptions) (*Plugin, error) {
+	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp directory: %w", err)
+	}
+	removeDir := func() { os.RemoveAll(tmpDir) }
+
+	// Create shared memory file
+	shmPath := filepath.Join(tmpDir, "shared.mem")
+	shmFile, err := os.Create(shmPath)
+	if err != nil {
+		removeDir()
+		return nil, fmt.Errorf("create shared memory file: %w", err)
+	}
+	defer shmFile.Close()
+
+	// Resize file to the region size. The new pages read as zeros.
+	if err := shmFile.Truncate(protocol.MmapSize); err != nil {
+		removeDir()
+		return nil, fmt.Errorf("resize shared memory file: %w", err)
+	}
+
+	// Memory map the file
+	data, err := syscall.Mmap(int(shmFile.Fd()), 0, protocol.MmapSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		removeDir()
+		return nil, fmt.Errorf("mmap file: %w", err)
+	}
+	release := func() {
+		syscall.Munmap(data)
+		removeDir()
+	}
+
+	// Start the plugin process
+	cmd := opts.command(path, "mmap", shmPath)
+	cmd.Stderr = opts.Stderr
+	exceeded, err := opts.start(cmd)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+
+	// Sleep while waiting for the plugin to start up, then switch to the
+	// chosen strategy
+	conn := protocol.NewMmapConn(data, protocol.SideHost, protocol.WaitSleep)
+	p, err := newPlugin(ctx, cmd, exceeded, conn, opts.hello(protocol.MaxMmapPayload), opts.Client, release)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetWaitStrategy(opts.Wait)
+	return p, nil
+}
+
+// startTimeout bounds how long a plugin may take to start when the caller
+// sets no deadline, and how long a restart may take. Tests shorten it.
+var startTimeout = 5 * time.Second
+
+// withStartTimeout returns ctx bounded by startTimeout if it has no
+// deadline of its own.
+func withStartTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, startTimeout)
+}
@@ -0,0 +1,96 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/affinity"
+)
+
+func TestLaunchUnknownTransport(t *testing.T) {
+	_, err := Launch(context.Background(), "/nonexistent", LaunchOptions{Transport: "pigeon"})
+	if err == nil || !strings.Contains(err.Error(), `"pigeon"`) {
+		t.Errorf("Expected unknown transport error, got %v", err)
+	}
+}
+
+func TestLaunchDefaultsToStdio(t *testing.T) {
+	_, err := Launch(context.Background(), "/nonexistent", LaunchOptions{})
+	if err == nil || strings.Contains(err.Error(), "unknown transport") {
+		t.Errorf("Expected the stdio plugin to fail to start, got %v", err)
+	}
+}
+
+// TestLaunchNotReady starts plugins that do not signal they are ready.
+func TestLaunchNotReady(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{"exits", "exit 1", "plugin exited before it was ready"},
+		{"prints", "echo hello; exec sleep 10", `plugin printed "hello"`},
+		{"hangs", "exec sleep 10", "i/o timeout"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			// The transport's arguments follow the script as $0 and on
+			_, err := Launch(ctx, "/bin/sh", LaunchOptions{Transport: "unix", Args: []string{"-c", tt.script}})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Launch: got %v, want error containing %s", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestLaunchNoHello starts plugins that never send their hello, with no
+// deadline but the start timeout.
+func TestLaunchNoHello(t *testing.T) {
+	defer func(timeout time.Duration) { startTimeout = timeout }(startTimeout)
+	startTimeout = 100 * time.Millisecond
+	for _, transport := range []string{"stdio", "mmap"} {
+		t.Run(transport, func(t *testing.T) {
+			start := time.Now()
+			_, err := Launch(context.Background(), "/bin/sh", LaunchOptions{Transport: transport, Args: []string{"-c", "exec sleep 10"}})
+			if err == nil {
+				t.Fatal("Launch of a plugin that never sends hello succeeded")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Launch took %v to give up", elapsed)
+			}
+		})
+	}
+}
+
+// TestStartPinned checks a plugin pinned to a CPU runs there from its first
+// instruction, with and without limits.
+func TestStartPinned(t *testing.T) {
+	allowed, err := affinity.Allowed()
+	if err != nil {
+		t.Skipf("CPU affinity unavailable: %v", err)
+	}
+	if len(allowed) < 2 {
+		t.Skip("A pinned process cannot be told apart on one CPU")
+	}
+	cpu := allowed[len(allowed)-1]
+	for _, limits := range []Limits{{}, {NoCore: true}} {
+		o := LaunchOptions{CPUs: []int{cpu}, Limits: limits}
+		var out strings.Builder
+		cmd := exec.Command("/bin/sh", "-c", "grep Cpus_allowed_list /proc/self/status")
+		cmd.Stdout = &out
+		if _, err := o.start(cmd); err != nil {
+			t.Fatalf("start with %+v: %v", limits, err)
+		}
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+		if got := strings.TrimSpace(strings.TrimPrefix(out.String(), "Cpus_allowed_list:")); got != fmt.Sprint(cpu) {
+			t.Errorf("With %+v the plugin started on CPUs %s, want %d", limits, got, cpu)
+		}
+	}
+}
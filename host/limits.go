@@ -0,0 +1,92 @@
+package host
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Limits bound the resources a plugin process may use. They are applied as
+// hard rlimits before the plugin runs any of its own code, so it cannot
+// raise them again. Zero fields leave the limit the plugin inherits from the
+// host.
+type Limits struct {
+	// AddressSpace is the most virtual memory the plugin may map, in bytes.
+	// A Go program reserves several hundred megabytes of address space as
+	// it starts, so a Go plugin needs a limit of around 1GB to run at all.
+	AddressSpace uint64 `json:"address_space,omitempty"`
+	// OpenFiles is the most file descriptors the plugin may have open.
+	OpenFiles uint64 `json:"open_files,omitempty"`
+	// CPUSeconds is the most CPU time the plugin may use. It is killed with
+	// SIGKILL when it reaches it.
+	CPUSeconds uint64 `json:"cpu_seconds,omitempty"`
+	// NoCore stops the plugin writing a core dump if it crashes.
+	NoCore bool `json:"no_core,omitempty"`
+}
+
+// Names of the limits as ExitError.Exceeded reports them.
+const (
+	LimitAddressSpace = "address_space"
+	LimitCPUSeconds   = "cpu_seconds"
+)
+
+// rlimits returns the rlimits to apply for l.
+func (l Limits) rlimits() map[int]uint64 {
+	rlimits := map[int]uint64{}
+	if l.AddressSpace != 0 {
+		rlimits[syscall.RLIMIT_AS] = l.AddressSpace
+	}
+	if l.OpenFiles != 0 {
+		rlimits[syscall.RLIMIT_NOFILE] = l.OpenFiles
+	}
+	if l.CPUSeconds != 0 {
+		rlimits[syscall.RLIMIT_CPU] = l.CPUSeconds
+	}
+	if l.NoCore {
+		rlimits[syscall.RLIMIT_CORE] = 0
+	}
+	return rlimits
+}
+
+// cpuSlack is how far short of its CPU time limit a process killed for
+// reaching it may be reported to be. The kernel checks the limit against
+// its own sampled accounting, which runs ahead of the total reported when
+// the process is reaped by a few clock ticks.
+const cpuSlack = 100 * time.Millisecond
+
+// goFatalStatus is the status a Go program exits with when the runtime
+// gives up, as it does when it cannot map memory, or panics.
+const goFatalStatus = 2
+
+// exceeded returns the name of the limit in l that the process that ended
+// in state was killed for exceeding, or "" if it was not. It goes by how
+// the process ended, never by what it said. A process that cannot map
+// memory under an address space limit is not killed but fails, and a Go
+// program then exits with goFatalStatus; a Go plugin under such a limit
+// that panics is reported the same way.
+func (l Limits) exceeded(state *os.ProcessState) string {
+	if state == nil || state.Success() {
+		return ""
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	// The kernel sends SIGXCPU at the limit, which Go programs ignore, and
+	// SIGKILL at the hard limit
+	if l.CPUSeconds != 0 && ws.Signaled() && (ws.Signal() == syscall.SIGKILL || ws.Signal() == syscall.SIGXCPU) {
+		if state.UserTime()+state.SystemTime() >= time.Duration(l.CPUSeconds)*time.Second-cpuSlack {
+			return LimitCPUSeconds
+		}
+	}
+	if l.AddressSpace != 0 && ws.Exited() && ws.ExitStatus() == goFatalStatus {
+		return LimitAddressSpace
+	}
+	return ""
+}
+
+// startLimited starts cmd with the limits in l.
+func startLimited(cmd *exec.Cmd, l Limits) error {
+	return startRlimited(cmd, l.rlimits())
+}
@@ -0,0 +1,53 @@
+package host
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// startRlimited starts cmd with the hard and soft limits of each resource in
+// rlimits set to the value it maps to. Go cannot run code in the child
+// between fork and exec, so the child is traced: it stops as it execs, before
+// running any instructions of its own, while the limits are set from
+// outside with prlimit.
+func startRlimited(cmd *exec.Cmd, rlimits map[int]uint64) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	// Only the thread that started the child may detach from it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	fail := func(err error) error {
+		cmd.Process.Kill()
+		syscall.PtraceDetach(pid)
+		cmd.Wait()
+		return fmt.Errorf("apply resource limits: %w", err)
+	}
+
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+		return fail(err)
+	}
+	if !ws.Stopped() {
+		return fail(fmt.Errorf("plugin did not stop at exec: %v", ws))
+	}
+	for resource, value := range rlimits {
+		rlimit := syscall.Rlimit{Cur: value, Max: value}
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0); errno != 0 {
+			return fail(fmt.Errorf("prlimit %d: %w", resource, errno))
+		}
+	}
+	if err := syscall.PtraceDetach(pid); err != nil {
+		return fail(err)
+	}
+	return nil
+}
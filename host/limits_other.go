@@ -0,0 +1,13 @@
+//go:build !linux
+
+package host
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+)
+
+func startRlimited(cmd *exec.Cmd, rlimits map[int]uint64) error {
+	return errors.New("resource limits are not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,48 @@
+package host
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+// TestLimitsExceededStatus tells which limit a process exceeded from how it
+// ended, whatever it printed.
+func TestLimitsExceededStatus(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	exit := func(script string) *os.ProcessState {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Run()
+		return cmd.ProcessState
+	}
+	for _, tt := range []struct {
+		name   string
+		limits Limits
+		state  *os.ProcessState
+		want   string
+	}{
+		{"fatal", Limits{AddressSpace: 1 << 30}, exit("exit 2"), LimitAddressSpace},
+		{"fatal unlimited", Limits{OpenFiles: 64}, exit("exit 2"), ""},
+		{"says out of memory", Limits{AddressSpace: 1 << 30}, exit("echo 'fatal error: runtime: out of memory' >&2; exit 1"), ""},
+		{"success", Limits{AddressSpace: 1 << 30}, exit("exit 0"), ""},
+		{"killed early", Limits{CPUSeconds: 60}, exit("kill -9 $$"), ""},
+	} {
+		if got := tt.limits.exceeded(tt.state); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLaunchLimitsMissingPlugin(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	_, err := Launch(context.Background(), "/nonexistent", LaunchOptions{Limits: Limits{OpenFiles: 16}})
+	if err == nil {
+		t.Errorf("Launch of a missing plugin succeeded")
+	}
+}
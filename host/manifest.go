@@ -0,0 +1,249 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// Manifest describes a plugin so that a host can find and launch it without
+// knowing about it in advance. Manifests are JSON files:
+//
+//	{
+//		"name": "kv",
+//		"package": "../kv",
+//		"transports": ["unix", "stdio"],
+//		"protocol": 1,
+//		"limits": {"open_files": 64, "no_core": true}
+//	}
+type Manifest struct {
+	// Name identifies the plugin. It must be unique within a directory and
+	// a file name, without any directory.
+	Name string `json:"name"`
+
+	// Binary is the path of the plugin's executable and Package the Go
+	// package to build it from. Exactly one must be set. Relative paths are
+	// relative to Dir.
+	Binary  string `json:"binary,omitempty"`
+	Package string `json:"package,omitempty"`
+
+	// Args are passed to the plugin ahead of the transport's arguments.
+	Args []string `json:"args,omitempty"`
+
+	// Transports are the transports the plugin serves, most preferred
+	// first.
+	Transports []string `json:"transports"`
+
+	// Protocol is the protocol version the plugin speaks.
+	Protocol uint16 `json:"protocol"`
+
+	// Limits are the resources the plugin may use.
+	Limits Limits `json:"limits,omitzero"`
+
+	// Sandbox launches the plugin sandboxed, as LaunchOptions.Sandbox does.
+	Sandbox bool `json:"sandbox,omitempty"`
+
+	// Dir is the directory the manifest was read from.
+	Dir string `json:"-"`
+}
+
+// ReadManifest reads and validates the manifest at path.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.Dir = filepath.Dir(path)
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Validate reports whether m describes a plugin this host can launch.
+func (m *Manifest) Validate() error {
+	if m.Name == "" {
+		return errors.New("manifest has no name")
+	}
+	// The name is the file a plugin is built to, so it must stay in the
+	// build directory
+	if m.Name != filepath.Base(m.Name) || m.Name == "." || m.Name == ".." {
+		return fmt.Errorf("plugin name %q is not a single file name", m.Name)
+	}
+	if (m.Binary == "") == (m.Package == "") {
+		return fmt.Errorf("plugin %s must have one of binary and package", m.Name)
+	}
+	if len(m.Transports) == 0 {
+		return fmt.Errorf("plugin %s has no transports", m.Name)
+	}
+	for _, t := range m.Transports {
+		if !slices.Contains(Transports, t) {
+			return fmt.Errorf("plugin %s: unknown transport %q", m.Name, t)
+		}
+	}
+	if m.Protocol < protocol.MinVersion || m.Protocol > protocol.Version {
+		return fmt.Errorf("plugin %s speaks protocol version %d but versions %d to %d are supported", m.Name, m.Protocol, protocol.MinVersion, protocol.Version)
+	}
+	return nil
+}
+
+// Discover reads the manifests, files ending in .json, in dir. A manifest
+// that cannot be read or is invalid does not stop the others being
+// returned; the error reports every one of them.
+func Discover(dir string) ([]*Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*Manifest
+	var errs []error
+	names := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		m, err := ReadManifest(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if other, ok := names[m.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: plugin %s is already described by %s", path, m.Name, other))
+			continue
+		}
+		names[m.Name] = path
+		manifests = append(manifests, m)
+	}
+	return manifests, errors.Join(errs...)
+}
+
+// Transport returns the transport to launch the plugin on: preferred if the
+// plugin serves it, or else the first it lists if preferred is empty.
+func (m *Manifest) Transport(preferred string) (string, error) {
+	if preferred == "" {
+		return m.Transports[0], nil
+	}
+	if !slices.Contains(m.Transports, preferred) {
+		return "", fmt.Errorf("plugin %s does not serve %s, only %s", m.Name, preferred, strings.Join(m.Transports, ", "))
+	}
+	return preferred, nil
+}
+
+// Build builds the plugin's package to the executable output with the go
+// command, passing it flags such as -race. It is an error to build a plugin
+// that has a binary.
+func (m *Manifest) Build(ctx context.Context, output string, flags ...string) error {
+	if m.Package == "" {
+		return fmt.Errorf("plugin %s has a binary, not a package", m.Name)
+	}
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"build", "-o", output}, flags...)
+	args = append(args, m.Package)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = m.Dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("build plugin %s: go %s: %v\n%s", m.Name, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// executable returns the plugin's executable, building it into dir if it
+// has a package.
+func (m *Manifest) executable(ctx context.Context, dir string) (string, error) {
+	if m.Binary != "" {
+		if filepath.IsAbs(m.Binary) {
+			return m.Binary, nil
+		}
+		return filepath.Join(m.Dir, m.Binary), nil
+	}
+	path := filepath.Join(dir, m.Name)
+	return path, m.Build(ctx, path)
+}
+
+// LaunchManifest launches the plugin m describes, at path, on the transport
+// Transport picks for opts.Transport. The manifest's Args come before
+// opts.Args, and its Limits apply unless opts has limits of its own. The
+// plugin is sandboxed if either the manifest or opts asks for it. A plugin
+// that does not speak the protocol version in m is closed.
+func LaunchManifest(ctx context.Context, m *Manifest, path string, opts LaunchOptions) (*Plugin, error) {
+	transport, err := m.Transport(opts.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limits == (Limits{}) {
+		opts.Limits = m.Limits
+	}
+	opts.Sandbox = opts.Sandbox || m.Sandbox
+	opts.Transport = transport
+	opts.Args = append(slices.Clip(m.Args), opts.Args...)
+	p, err := Launch(ctx, path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", m.Name, err)
+	}
+	if err := m.checkHello(p.Client.Hello()); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkHello returns an error if the plugin's hello is not for the protocol
+// version m says it speaks.
+func (m *Manifest) checkHello(hello *protocol.Hello) error {
+	if hello.Version != m.Protocol {
+		return fmt.Errorf("plugin %s speaks protocol version %d but its manifest says %d", m.Name, hello.Version, m.Protocol)
+	}
+	return nil
+}
+
+// LaunchDir discovers the plugins in dir and launches each of them with
+// opts, building those that have a package first. It returns the plugins
+// that started by name. Plugins that could not be discovered or launched
+// are skipped and reported in the error.
+func LaunchDir(ctx context.Context, dir string, opts LaunchOptions) (map[string]*Plugin, error) {
+	manifests, err := Discover(dir)
+	errs := []error{err}
+
+	// The plugins are built to a directory that is only needed until they
+	// have started
+	buildDir, err := os.MkdirTemp("", "goipcbench-build-*")
+	if err != nil {
+		return nil, fmt.Errorf("create build directory: %w", err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	plugins := map[string]*Plugin{}
+	for _, m := range manifests {
+		path, err := m.executable(ctx, buildDir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p, err := LaunchManifest(ctx, m, path, opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		plugins[m.Name] = p
+	}
+	return plugins, errors.Join(errs...)
+}
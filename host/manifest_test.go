@@ -0,0 +1,125 @@
+package host
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// writeManifests writes each of manifests, by file name, to a new directory.
+func writeManifests(t *testing.T, manifests map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range manifests {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadManifest(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"valid", `{"name": "kv", "package": "../kv", "transports": ["unix", "stdio"], "protocol": 1, "limits": {"open_files": 64}, "sandbox": true}`, ""},
+		{"no name", `{"package": "../kv", "transports": ["stdio"], "protocol": 1}`, "no name"},
+		{"name outside directory", `{"name": "../kv", "package": "../kv", "transports": ["stdio"], "protocol": 1}`, "not a single file name"},
+		{"name with directory", `{"name": "a/kv", "package": "../kv", "transports": ["stdio"], "protocol": 1}`, "not a single file name"},
+		{"name dot dot", `{"name": "..", "package": "../kv", "transports": ["stdio"], "protocol": 1}`, "not a single file name"},
+		{"binary and package", `{"name": "kv", "binary": "kv", "package": "../kv", "transports": ["stdio"], "protocol": 1}`, "one of binary and package"},
+		{"neither binary nor package", `{"name": "kv", "transports": ["stdio"], "protocol": 1}`, "one of binary and package"},
+		{"no transports", `{"name": "kv", "package": "../kv", "protocol": 1}`, "no transports"},
+		{"unknown transport", `{"name": "kv", "package": "../kv", "transports": ["pigeon"], "protocol": 1}`, `"pigeon"`},
+		{"old protocol", `{"name": "kv", "package": "../kv", "transports": ["stdio"], "protocol": 0}`, "protocol version 0"},
+		{"new protocol", `{"name": "kv", "package": "../kv", "transports": ["stdio"], "protocol": 99}`, "protocol version 99"},
+		{"unknown field", `{"name": "kv", "package": "../kv", "transports": ["stdio"], "protocol": 1, "colour": "blue"}`, `"colour"`},
+		{"not JSON", `name = "kv"`, "invalid character"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeManifests(t, map[string]string{"kv.json": tt.manifest})
+			m, err := ReadManifest(filepath.Join(dir, "kv.json"))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("ReadManifest: %v", err)
+				}
+				if m.Dir != dir || m.Limits.OpenFiles != 64 || !m.Sandbox || m.Transports[0] != "unix" {
+					t.Errorf("ReadManifest: got %+v", m)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadManifest: got %v, want error containing %s", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestDiscover returns the valid manifests in a directory along with an
+// error for the rest.
+func TestDiscover(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"a.json":     `{"name": "kv", "binary": "kv", "transports": ["stdio"], "protocol": 1}`,
+		"b.json":     `{"name": "kv", "binary": "kv2", "transports": ["stdio"], "protocol": 1}`,
+		"c.json":     `{"name": "echo", "binary": "/bin/echo", "transports": ["tcp"], "protocol": 1}`,
+		"d.json":     `{"name": "broken"}`,
+		"README.txt": `Not a manifest`,
+	})
+	manifests, err := Discover(dir)
+	if len(manifests) != 2 || manifests[0].Name != "kv" || manifests[0].Binary != "kv" || manifests[1].Name != "echo" {
+		t.Errorf("Discover: got %d manifests", len(manifests))
+	}
+	if err == nil || !strings.Contains(err.Error(), "b.json: plugin kv is already described") || !strings.Contains(err.Error(), "d.json") {
+		t.Errorf("Discover: got error %v", err)
+	}
+
+	if _, err := Discover(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Discover of a missing directory succeeded")
+	}
+}
+
+func TestManifestTransport(t *testing.T) {
+	m := &Manifest{Name: "kv", Transports: []string{"unix", "stdio"}}
+	if transport, err := m.Transport(""); err != nil || transport != "unix" {
+		t.Errorf("Transport(\"\"): got %s, %v", transport, err)
+	}
+	if transport, err := m.Transport("stdio"); err != nil || transport != "stdio" {
+		t.Errorf("Transport(stdio): got %s, %v", transport, err)
+	}
+	if _, err := m.Transport("mmap"); err == nil || !strings.Contains(err.Error(), "only unix, stdio") {
+		t.Errorf("Transport(mmap): got %v", err)
+	}
+}
+
+func TestManifestCheckHello(t *testing.T) {
+	m := &Manifest{Name: "kv", Protocol: protocol.Version}
+	if err := m.checkHello(protocol.NewHello("kv", 0)); err != nil {
+		t.Errorf("checkHello of the same version: %v", err)
+	}
+	hello := protocol.NewHello("kv", 0)
+	hello.Version++
+	if err := m.checkHello(hello); err == nil || !strings.Contains(err.Error(), "its manifest says") {
+		t.Errorf("checkHello of another version: got %v", err)
+	}
+}
+
+// TestLaunchDirFailures reports the plugins that could not be launched.
+func TestLaunchDirFailures(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"missing.json": `{"name": "missing", "binary": "missing", "transports": ["stdio"], "protocol": 1}`,
+		"tcp.json":     `{"name": "tcp", "binary": "/bin/true", "transports": ["tcp"], "protocol": 1}`,
+	})
+	plugins, err := LaunchDir(context.Background(), dir, LaunchOptions{Transport: "stdio"})
+	if len(plugins) != 0 {
+		t.Errorf("LaunchDir: got %d plugins", len(plugins))
+	}
+	if err == nil || !strings.Contains(err.Error(), "plugin missing") || !strings.Contains(err.Error(), "plugin tcp does not serve stdio") {
+		t.Errorf("LaunchDir: got error %v", err)
+	}
+}
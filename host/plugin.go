@@ -0,0 +1,220 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// exitGrace is how long a failed call waits for the process to be reaped
+// before deciding the plugin is still alive. A dead plugin's connection can
+// fail slightly before Wait returns.
+const exitGrace = 100 * time.Millisecond
+
+// closeTimeout is how long Close waits for the plugin to acknowledge a
+// shutdown and exit before killing it.
+const closeTimeout = 2 * time.Second
+
+// ExitError is returned by calls to a plugin whose process has exited.
+type ExitError struct {
+	// Err is the error from waiting for the process. It is nil if the process
+	// exited with status 0.
+	Err error
+
+	// Exceeded names the limit the process was killed for exceeding, such
+	// as LimitCPUSeconds, or is empty if it was not.
+	Exceeded string
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return "plugin exited"
+	}
+	if e.Exceeded != "" {
+		return fmt.Sprintf("plugin exceeded its %s limit: %v", e.Exceeded, e.Err)
+	}
+	return "plugin exited: " + e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Plugin is a running plugin process and the client connected to it.
+type Plugin struct {
+	Cmd    *exec.Cmd
+	Client *Client
+
+	// exceeded tells which limit the process was killed for exceeding. It
+	// is nil if the process has no limits.
+	exceeded func(*os.ProcessState) string
+
+	exited  chan struct{}
+	exitErr *ExitError
+
+	// release frees transport resources once no calls can be in progress.
+	release   []func()
+	closeOnce sync.Once
+}
+
+// newPlugin watches the started cmd and shakes hands with it over conn,
+// for at most startTimeout unless ctx has a deadline.
+// exceeded is what LaunchOptions.start returned for it. release is called
+// by Close. If the handshake fails the process is killed and released.
+func newPlugin(ctx context.Context, cmd *exec.Cmd, exceeded func(*os.ProcessState) string, conn protocol.Conn, hello *protocol.Hello, opts ClientOptions, release ...func()) (*Plugin, error) {
+	p := &Plugin{
+		Cmd:      cmd,
+		Client:   NewClient(conn, opts),
+		exceeded: exceeded,
+		exited:   make(chan struct{}),
+		release:  release,
+	}
+	go p.watch()
+
+	// Give up on the handshake if the plugin dies during it, or takes more
+	// than startTimeout when ctx has no deadline
+	ctx, cancel := withStartTimeout(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if _, err := p.Client.Handshake(ctx, hello); err != nil {
+		p.Kill()
+		p.Close()
+		if p.Err() != nil && ctx.Err() != nil {
+			return nil, p.Err()
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// watch waits for the process to exit, then closes the connection so that
+// any call waiting on it fails.
+func (p *Plugin) watch() {
+	err := p.Cmd.Wait()
+	p.exitErr = &ExitError{Err: err}
+	if p.exceeded != nil {
+		p.exitErr.Exceeded = p.exceeded(p.Cmd.ProcessState)
+	}
+	p.Client.Conn().Close()
+	close(p.exited)
+}
+
+// Exited returns a channel that is closed when the process exits.
+func (p *Plugin) Exited() <-chan struct{} {
+	return p.exited
+}
+
+// Err returns why the process exited, or nil while it is running.
+func (p *Plugin) Err() *ExitError {
+	select {
+	case <-p.exited:
+		return p.exitErr
+	default:
+		return nil
+	}
+}
+
+// Call calls method on the plugin. If the process has died the error is an
+// *ExitError.
+func (p *Plugin) Call(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	response, err := p.Client.Call(ctx, method, payload)
+	if err != nil {
+		return nil, p.callErr(err)
+	}
+	return response, nil
+}
+
+// Stream calls method on the plugin and returns its streamed reply, as
+// Client.Stream does. If the process has died the error is an *ExitError.
+func (p *Plugin) Stream(ctx context.Context, method string, payload []byte) (*Stream, error) {
+	s, err := p.Client.Stream(ctx, method, payload)
+	if err != nil {
+		return nil, p.callErr(err)
+	}
+	return s, nil
+}
+
+// callErr returns the error for a failed call, which is an *ExitError if the
+// process has died.
+func (p *Plugin) callErr(err error) error {
+	if isReply(err) {
+		return err
+	}
+	grace := exitGrace
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		grace = 0
+	}
+	select {
+	case <-p.exited:
+		return p.exitErr
+	case <-time.After(grace):
+		return err
+	}
+}
+
+// Kill kills the process without waiting for it to exit.
+func (p *Plugin) Kill() error {
+	return p.Cmd.Process.Kill()
+}
+
+// Shutdown asks the plugin to shut down and waits for it to exit. If the
+// process exits with an error, the error is an *ExitError.
+func (p *Plugin) Shutdown(ctx context.Context) error {
+	if err := p.Client.Shutdown(ctx); err != nil {
+		// The process can exit before its acknowledgement is read
+		select {
+		case <-p.exited:
+		case <-time.After(exitGrace):
+			return err
+		}
+	} else {
+		select {
+		case <-p.exited:
+		case <-ctx.Done():
+			return fmt.Errorf("plugin did not exit after shutdown: %w", ctx.Err())
+		}
+	}
+
+	if p.exitErr.Err != nil {
+		return p.exitErr
+	}
+	return nil
+}
+
+// Close shuts the plugin down if it is still running and releases its
+// transport resources. A plugin that does not shut down cleanly within
+// closeTimeout is killed and Close returns an error. Close must not be
+// called while a call is in progress.
+func (p *Plugin) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		select {
+		case <-p.exited:
+		default:
+			ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+			defer cancel()
+			if err = p.Shutdown(ctx); err != nil {
+				p.Kill()
+				<-p.exited
+			}
+		}
+
+		for _, release := range p.release {
+			release()
+		}
+	})
+	return err
+}
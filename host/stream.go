@@ -0,0 +1,127 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// errStreamClosed is returned by Recv on a stream closed before its end.
+var errStreamClosed = errors.New("stream closed")
+
+// Stream is the streamed reply to a request, received a chunk at a time.
+type Stream struct {
+	c      *Client
+	ctx    context.Context
+	method string
+	id     uint32
+	window uint32
+
+	// received counts the chunks received since credits were last granted.
+	received uint32
+	// err is io.EOF once the stream has ended, or why it failed.
+	err error
+}
+
+// Stream sends a request for method whose reply the plugin streams with
+// plugin.Request.Send, and returns the stream to receive it from. ctx bounds
+// the whole stream. The plugin sends at most the StreamWindow of chunks
+// ahead of Recv, so a host that stops receiving holds the plugin up rather
+// than having the chunks pile up.
+//
+// Any other call on the Client closes the stream.
+func (c *Client) Stream(ctx context.Context, method string, payload []byte) (*Stream, error) {
+	if c.hello != nil && !slices.Contains(c.hello.Types, protocol.TypeStream) {
+		return nil, fmt.Errorf("plugin %q does not support streams", c.hello.Name)
+	}
+	stop, err := c.watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	if err := c.settle(); err != nil {
+		return nil, c.ctxErr(ctx, method, err)
+	}
+
+	c.nextID++
+	s := &Stream{c: c, ctx: ctx, method: method, id: c.nextID, window: c.opts.StreamWindow}
+	if s.window == 0 {
+		s.window = DefaultStreamWindow
+	}
+	if err := c.conn.WriteMessage(&protocol.Message{Type: protocol.TypeStream, ID: s.id, Method: method, Payload: payload}); err != nil {
+		return nil, c.ctxErr(ctx, method, err)
+	}
+	if err := c.conn.WriteMessage(protocol.CreditMessage(s.id, s.window)); err != nil {
+		return nil, c.ctxErr(ctx, method, err)
+	}
+	c.stream = s
+	return s, nil
+}
+
+// Recv returns the next chunk of the stream, or io.EOF once the plugin has
+// ended it. An error reply from the plugin is returned as a *protocol.Error.
+// If ctx is done the stream is canceled and the plugin's remaining chunks
+// are discarded at the start of the next call.
+func (s *Stream) Recv() ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	chunk, err := s.recv()
+	if err != nil {
+		s.end(err)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			s.c.abandon(s.id, true)
+		}
+	}
+	return chunk, err
+}
+
+func (s *Stream) recv() ([]byte, error) {
+	stop, err := s.c.watch(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.method, err)
+	}
+	defer stop()
+
+	// Make room for more once half the window has been received
+	if s.received >= max(s.window/2, 1) {
+		if err := s.c.conn.WriteMessage(protocol.CreditMessage(s.id, s.received)); err != nil {
+			return nil, s.c.ctxErr(s.ctx, s.method, err)
+		}
+		s.received = 0
+	}
+
+	msg, err := s.c.next(s.id, false)
+	if err != nil {
+		return nil, s.c.ctxErr(s.ctx, s.method, err)
+	}
+	if msg.Type == protocol.TypeResponse {
+		return nil, io.EOF
+	}
+	s.received++
+	return msg.Payload, nil
+}
+
+// Close stops receiving the stream. If it has not ended the plugin is told
+// to stop sending, and its remaining chunks are discarded at the start of
+// the next call.
+func (s *Stream) Close() error {
+	if s.err == nil {
+		s.end(errStreamClosed)
+		s.c.abandon(s.id, true)
+	}
+	return nil
+}
+
+// end records that the stream is over with err.
+func (s *Stream) end(err error) {
+	s.err = err
+	if s.c.stream == s {
+		s.c.stream = nil
+	}
+}
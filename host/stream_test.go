@@ -0,0 +1,197 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// streamPlugin serves stream methods on pluginConn:
+//
+//   - count sends the numbers up to the payload, counting them in sent.
+//   - lookup sends a chunk, the value of kv.get for the payload from the
+//     host and another chunk.
+//   - fail sends a chunk and fails.
+//
+// count reports how it ended on ended.
+func streamPlugin(pluginConn protocol.Conn, sent *atomic.Int32, ended chan<- error) {
+	s := plugin.New("streamer")
+	s.Handle("ping", func(*plugin.Request) ([]byte, error) { return []byte("pong"), nil })
+	s.HandleStream("count", func(req *plugin.Request) error {
+		var n int
+		fmt.Sscan(string(req.Payload), &n)
+		for i := range n {
+			if err := req.Send(fmt.Append(nil, i)); err != nil {
+				ended <- err
+				return err
+			}
+			sent.Add(1)
+		}
+		ended <- nil
+		return nil
+	})
+	s.HandleStream("lookup", func(req *plugin.Request) error {
+		if err := req.Send([]byte("before")); err != nil {
+			return err
+		}
+		value, err := req.Call("kv.get", req.Payload)
+		if err != nil {
+			return err
+		}
+		if err := req.Send(value); err != nil {
+			return err
+		}
+		return req.Send([]byte("after"))
+	})
+	s.HandleStream("fail", func(req *plugin.Request) error {
+		if err := req.Send([]byte("partial")); err != nil {
+			return err
+		}
+		return errors.New("broken")
+	})
+	// The connection is closed under the plugin at the end of the test
+	go s.ServeConn(pluginConn, protocol.MaxMmapPayload)
+}
+
+// recvAll receives the rest of s.
+func recvAll(s *Stream) ([]string, error) {
+	var chunks []string
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, string(chunk))
+	}
+}
+
+// testStream streams over the conns. ahead is how many chunks the plugin
+// should send before the host receives any.
+func testStream(t *testing.T, hostConn, pluginConn protocol.Conn, ahead int32) {
+	var sent atomic.Int32
+	ended := make(chan error, 10)
+	streamPlugin(pluginConn, &sent, ended)
+
+	const window = 4
+	client := NewClient(hostConn, ClientOptions{
+		StreamWindow: window,
+		Handler: func(method string, payload []byte) ([]byte, error) {
+			return []byte("blue"), nil
+		},
+	})
+	ctx := context.Background()
+	if _, err := client.Handshake(ctx, protocol.NewHello("host", protocol.MaxMmapPayload)); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+
+	// The plugin sends no more than it is allowed ahead of the host
+	s, err := client.Stream(ctx, "count", []byte("100"))
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := sent.Load(); n != ahead {
+		t.Errorf("Plugin sent %d chunks before the host received any, want %d", n, ahead)
+	}
+	chunks, err := recvAll(s)
+	if err != nil || len(chunks) != 100 || chunks[0] != "0" || chunks[99] != "99" {
+		t.Fatalf("Recv: got %d chunks, %v", len(chunks), err)
+	}
+	if err := <-ended; err != nil {
+		t.Errorf("count: %v", err)
+	}
+	if _, err := s.Recv(); err != io.EOF {
+		t.Errorf("Recv after end: got %v, want io.EOF", err)
+	}
+
+	// The plugin can call back into the host while streaming
+	s, err = client.Stream(ctx, "lookup", []byte("color"))
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if chunks, err := recvAll(s); err != nil || fmt.Sprint(chunks) != "[before blue after]" {
+		t.Errorf("lookup: got %q, %v", chunks, err)
+	}
+
+	// An error after some chunks ends the stream
+	s, err = client.Stream(ctx, "fail", nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	chunks, err = recvAll(s)
+	var perr *protocol.Error
+	if len(chunks) != 1 || !errors.As(err, &perr) || perr.Code != protocol.CodeInternal {
+		t.Errorf("fail: got %q, %v", chunks, err)
+	}
+
+	// Closing a stream cancels it and the next call still works
+	s, err = client.Stream(ctx, "count", []byte("1000000"))
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if _, err := s.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	s.Close()
+	if _, err := s.Recv(); err == nil {
+		t.Errorf("Recv after Close succeeded")
+	}
+	if resp, err := client.Call(ctx, "ping", nil); err != nil || string(resp) != "pong" {
+		t.Fatalf("Call after Close: got %q, %v", resp, err)
+	}
+	if err := <-ended; err == nil {
+		t.Errorf("count was not canceled")
+	}
+
+	// Calling a stream method as a plain call is refused
+	_, err = client.Call(ctx, "count", []byte("1"))
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeUnknownMethod {
+		t.Errorf("Call of stream method: got %v", err)
+	}
+}
+
+func TestStreamSocket(t *testing.T) {
+	hostConn, pluginConn := socketPair(t)
+	testStream(t, hostConn, pluginConn, 4)
+}
+
+// TestStreamMmap streams over shared memory, where the region holds one
+// chunk at a time so the plugin cannot get further ahead than that.
+func TestStreamMmap(t *testing.T) {
+	data := make([]byte, protocol.MmapSize)
+	testStream(t, protocol.NewMmapConn(data, protocol.SideHost, protocol.WaitYield), protocol.NewMmapConn(data, protocol.SidePlugin, protocol.WaitYield), 1)
+}
+
+// TestStreamContext gives up on a stream when its context is done.
+func TestStreamContext(t *testing.T) {
+	hostConn, pluginConn := socketPair(t)
+	var sent atomic.Int32
+	streamPlugin(pluginConn, &sent, make(chan error, 10))
+
+	client := NewClient(hostConn, ClientOptions{})
+	if _, err := client.Handshake(context.Background(), protocol.NewHello("host", protocol.MaxStreamPayload)); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := client.Stream(ctx, "count", []byte("1000000"))
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	cancel()
+	if _, err := s.Recv(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Recv: got %v, want context canceled", err)
+	}
+	if resp, err := client.Call(context.Background(), "ping", nil); err != nil || string(resp) != "pong" {
+		t.Fatalf("Call after cancel: got %q, %v", resp, err)
+	}
+}
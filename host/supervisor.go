@@ -0,0 +1,225 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls to a closed Supervisor.
+var ErrClosed = errors.New("supervisor closed")
+
+// ErrNotStarted is returned by calls to a Supervisor whose plugin has not
+// been started.
+var ErrNotStarted = errors.New("supervisor not started")
+
+// StartFunc starts a plugin process, for example by calling StartUnix.
+type StartFunc func(ctx context.Context) (*Plugin, error)
+
+// SupervisorOptions configures a Supervisor.
+type SupervisorOptions struct {
+	// Restart makes the supervisor start a new process when the plugin dies.
+	Restart bool
+
+	// MinBackoff is the delay before the first restart attempt. Each failed
+	// attempt doubles it up to MaxBackoff. They default to 10ms and 5s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnExit, if set, is called with the reason each process exited.
+	OnExit func(err *ExitError)
+}
+
+// Supervisor watches a plugin process and optionally restarts it when it
+// dies. Calls through a Supervisor are serialized.
+type Supervisor struct {
+	start StartFunc
+	opts  SupervisorOptions
+
+	// callMu serializes calls, since a Client is not safe for concurrent
+	// use.
+	callMu sync.Mutex
+
+	mu       sync.Mutex
+	plugin   *Plugin
+	changed  chan struct{} // closed and replaced when plugin changes
+	restarts int
+	closed   bool
+	stop     context.CancelFunc
+	ctx      context.Context
+	wg       sync.WaitGroup
+}
+
+// NewSupervisor returns a Supervisor that starts plugins with start.
+func NewSupervisor(start StartFunc, opts SupervisorOptions) *Supervisor {
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = 10 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 5 * time.Second
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Supervisor{start: start, opts: opts, changed: make(chan struct{}), ctx: ctx, stop: stop}
+}
+
+// Start starts the first plugin process.
+func (s *Supervisor) Start(ctx context.Context) error {
+	p, err := s.start(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.setPlugin(p) {
+		p.Close()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Plugin returns the current plugin process. It may have exited.
+func (s *Supervisor) Plugin() *Plugin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.plugin
+}
+
+// Restarts returns how many times the plugin has been restarted.
+func (s *Supervisor) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+// setPlugin makes p the current plugin, closing the one it replaces, and
+// starts watching it. It returns false, leaving p to the caller, if the
+// supervisor has been closed.
+func (s *Supervisor) setPlugin(p *Plugin) bool {
+	// The previous plugin must not be in a call when it is closed
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.plugin != nil {
+		s.plugin.Close()
+		s.restarts++
+	}
+	s.plugin = p
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.watch(p)
+	return true
+}
+
+// watch waits for p to exit and restarts it if configured to.
+func (s *Supervisor) watch(p *Plugin) {
+	defer s.wg.Done()
+	select {
+	case <-p.Exited():
+	case <-s.ctx.Done():
+		return
+	}
+
+	if s.opts.OnExit != nil {
+		s.opts.OnExit(p.Err())
+	}
+	if !s.opts.Restart {
+		return
+	}
+
+	backoff := s.opts.MinBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-s.ctx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(s.ctx, startTimeout)
+		next, err := s.start(ctx)
+		cancel()
+		if err == nil {
+			// Close may have taken the current plugin while it started
+			if !s.setPlugin(next) {
+				next.Close()
+			}
+			return
+		}
+		backoff = min(2*backoff, s.opts.MaxBackoff)
+	}
+}
+
+// Call calls method on the current plugin. If the plugin dies before the
+// call completes the error is an *ExitError. If Restart is set, a call made
+// while the plugin is dead waits for the restart to finish until ctx is done
+// or the supervisor is closed.
+func (s *Supervisor) Call(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	for {
+		response, changed, err := s.call(ctx, method, payload)
+		if changed == nil {
+			return response, err
+		}
+		select {
+		case <-changed:
+		case <-s.ctx.Done():
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// call calls method on the current plugin. If the plugin is dead and will be
+// restarted, it returns its exit error and a channel that is closed when it
+// is replaced, so the caller can wait without holding callMu.
+func (s *Supervisor) call(ctx context.Context, method string, payload []byte) ([]byte, <-chan struct{}, error) {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+
+	s.mu.Lock()
+	p, changed, closed := s.plugin, s.changed, s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, nil, ErrClosed
+	}
+	if p == nil {
+		return nil, nil, ErrNotStarted
+	}
+	if err := p.Err(); err != nil {
+		if !s.opts.Restart {
+			return nil, nil, err
+		}
+		return nil, changed, err
+	}
+
+	response, err := p.Call(ctx, method, payload)
+	return response, nil, err
+}
+
+// Close stops the supervisor and its plugin. It waits for a call in progress
+// to finish, but wakes calls waiting for a restart.
+func (s *Supervisor) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	// Cancelling ctx wakes waiting calls and stops the watchers, which need
+	// callMu to replace the plugin, so it must happen before taking callMu
+	s.stop()
+	s.wg.Wait()
+
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	s.mu.Lock()
+	p := s.plugin
+	s.mu.Unlock()
+	if p != nil {
+		return p.Close()
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestSupervisorNotStarted calls a supervisor whose plugin failed to start.
+func TestSupervisorNotStarted(t *testing.T) {
+	s := NewSupervisor(func(ctx context.Context) (*Plugin, error) {
+		return nil, errors.New("no plugin")
+	}, SupervisorOptions{Restart: true})
+	if _, err := s.Call(context.Background(), "ping", nil); err != ErrNotStarted {
+		t.Errorf("Call before Start: got %v, want ErrNotStarted", err)
+	}
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded")
+	}
+	if _, err := s.Call(context.Background(), "ping", nil); err != ErrNotStarted {
+		t.Errorf("Call after Start failed: got %v, want ErrNotStarted", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package ipcgen generates typed host stubs and plugin skeletons from Go
+// interfaces, so plugin APIs are checked at compile time rather than by
+// matching method names at run time.
+//
+// Each method of an interface must take a context.Context and one argument
+// and return one result and an error:
+//
+//	type KV interface {
+//		Get(ctx context.Context, key string) (string, error)
+//	}
+//
+// For an interface KV the generated code has a KVClient that implements KV
+// by calling a plugin, and a RegisterKV that serves an implementation of KV
+// from a plugin.Server. Methods are called as "KV.Get" and their argument
+// and result are encoded as JSON. A plugin serves one request at a time and
+// only reads the host's cancels between them, so the context a plugin's
+// implementation is given is never cancelled.
+package ipcgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Interface is a service interface to generate code for.
+type Interface struct {
+	Name    string
+	Methods []Method
+}
+
+// Method is a method of an Interface. Arg and Result are Go type
+// expressions in the package of the interface. ArgName is the name of the
+// argument in the generated client.
+type Method struct {
+	Name    string
+	ArgName string
+	Arg     string
+	Result  string
+}
+
+// Package is the interfaces found in a package's source.
+type Package struct {
+	Name       string
+	Interfaces []Interface
+	// Imports are the import specs, such as `"time"` or `t "time"`, that
+	// the argument and result types of the interfaces refer to.
+	Imports []string
+}
+
+// generatedImports are the imports generated code always has.
+var generatedImports = []string{
+	`"context"`,
+	`"encoding/json"`,
+	`"github.com/jackc/goipcbench/plugin"`,
+	`"github.com/jackc/goipcbench/protocol"`,
+}
+
+// Parse parses the Go files in dir, leaving out tests, and returns the
+// interfaces named by types.
+func Parse(dir string, types []string) (*Package, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return parseFiles(fset, files, types)
+}
+
+// parseFiles returns the interfaces named by types declared in files.
+func parseFiles(fset *token.FileSet, files []*ast.File, types []string) (*Package, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files")
+	}
+	pkg := &Package{Name: files[0].Name.Name}
+	for _, name := range types {
+		iface, file, err := findInterface(files, name)
+		if err != nil {
+			return nil, err
+		}
+		i, imports, err := parseInterface(fset, file, name, iface)
+		if err != nil {
+			return nil, err
+		}
+		pkg.Interfaces = append(pkg.Interfaces, *i)
+		for _, spec := range imports {
+			if !slices.Contains(pkg.Imports, spec) && !slices.Contains(generatedImports, spec) {
+				pkg.Imports = append(pkg.Imports, spec)
+			}
+		}
+	}
+	slices.Sort(pkg.Imports)
+	return pkg, nil
+}
+
+// findInterface returns the interface type declared as name and the file
+// declaring it.
+func findInterface(files []*ast.File, name string) (*ast.InterfaceType, *ast.File, error) {
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != name {
+					continue
+				}
+				iface, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					return nil, nil, fmt.Errorf("%s is not an interface", name)
+				}
+				return iface, f, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("type %s not found", name)
+}
+
+// parseInterface returns the Interface declared as iface in file and the
+// imports its types need.
+func parseInterface(fset *token.FileSet, file *ast.File, name string, iface *ast.InterfaceType) (*Interface, []string, error) {
+	i := &Interface{Name: name}
+	used := map[string]bool{}
+	for _, field := range iface.Methods.List {
+		pos := fset.Position(field.Pos())
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, nil, fmt.Errorf("%v: %s: embedded interfaces are not supported", pos, name)
+		}
+		method := field.Names[0].Name
+		params, results := flatten(fn.Params), flatten(fn.Results)
+		if len(params) != 2 || !isSelector(params[0], "context", "Context") {
+			return nil, nil, fmt.Errorf("%v: %s.%s must take a context.Context and one argument", pos, name, method)
+		}
+		if len(results) != 2 || !isIdent(results[1], "error") {
+			return nil, nil, fmt.Errorf("%v: %s.%s must return one result and an error", pos, name, method)
+		}
+		i.Methods = append(i.Methods, Method{
+			Name:    method,
+			ArgName: argName(fn.Params),
+			Arg:     typeString(fset, params[1]),
+			Result:  typeString(fset, results[0]),
+		})
+		packageNames(params[1], used)
+		packageNames(results[0], used)
+	}
+
+	var imports []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if used[name] {
+			imports = append(imports, importSpec(spec))
+		}
+	}
+	return i, imports, nil
+}
+
+// flatten returns the type of each parameter in fields, repeating a type
+// shared by several names.
+func flatten(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, field := range fields.List {
+		for range max(len(field.Names), 1) {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// argName returns the name of the argument after the context in params, or
+// "arg" if it has none or its name is taken in the generated client.
+func argName(params *ast.FieldList) string {
+	var names []string
+	for _, field := range params.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	if len(names) != 2 || slices.Contains([]string{"_", "c", "ctx", "result", "payload", "err"}, names[1]) {
+		return "arg"
+	}
+	return names[1]
+}
+
+func isSelector(expr ast.Expr, pkg, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && isIdent(sel.X, pkg) && sel.Sel.Name == name
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// packageNames adds the names of the packages expr refers to to used.
+func packageNames(expr ast.Expr, used map[string]bool) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+func typeString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, expr)
+	return buf.String()
+}
+
+func importSpec(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name + " " + spec.Path.Value
+	}
+	return spec.Path.Value
+}
+
+// importGroups returns the imports of generated code for pkg in the two
+// groups gofmt users expect: the standard library, whose paths have no dot
+// in their first element, and the rest. Each is sorted by path.
+func importGroups(pkg *Package) (std, other []string) {
+	for _, spec := range append(slices.Clone(generatedImports), pkg.Imports...) {
+		first, _, _ := strings.Cut(importPath(spec), "/")
+		if strings.Contains(first, ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	byPath := func(a, b string) int { return strings.Compare(importPath(a), importPath(b)) }
+	slices.SortFunc(std, byPath)
+	slices.SortFunc(other, byPath)
+	return std, other
+}
+
+// importPath returns the path an import spec imports.
+func importPath(spec string) string {
+	path, _ := strconv.Unquote(spec[strings.IndexByte(spec, '"'):])
+	return path
+}
+
+// Generate returns the generated Go source for pkg. command is the
+// command line recorded in the header.
+func Generate(pkg *Package, command string) ([]byte, error) {
+	std, other := importGroups(pkg)
+	var buf bytes.Buffer
+	err := fileTemplate.Execute(&buf, struct {
+		*Package
+		Command    string
+		StdImports []string
+		Imports    []string
+	}{pkg, command, std, other})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by {{.Command}}; DO NOT EDIT.
+
+package {{.Name}}
+
+import (
+{{- range .StdImports}}
+	{{.}}
+{{- end}}
+{{range .Imports}}
+	{{.}}
+{{- end}}
+)
+{{range .Interfaces}}{{$iface := .Name}}
+// {{.Name}}Client implements {{.Name}} by calling a plugin that serves it.
+type {{.Name}}Client struct {
+	caller protocol.Caller
+}
+
+// New{{.Name}}Client returns a {{.Name}}Client that makes calls with caller,
+// such as a *host.Plugin.
+func New{{.Name}}Client(caller protocol.Caller) *{{.Name}}Client {
+	return &{{.Name}}Client{caller: caller}
+}
+
+var _ {{.Name}} = (*{{.Name}}Client)(nil)
+{{range .Methods}}
+// {{.Name}} calls {{$iface}}.{{.Name}} on the plugin.
+func (c *{{$iface}}Client) {{.Name}}(ctx context.Context, {{.ArgName}} {{.Arg}}) ({{.Result}}, error) {
+	var result {{.Result}}
+	payload, err := json.Marshal({{.ArgName}})
+	if err != nil {
+		return result, err
+	}
+	payload, err = c.caller.Call(ctx, "{{$iface}}.{{.Name}}", payload)
+	if err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return result, protocol.Errorf(protocol.CodeMalformed, "{{$iface}}.{{.Name}} result: %v", err)
+	}
+	return result, nil
+}
+{{end}}
+// Register{{.Name}} registers the methods of impl on s, as {{.Name}}.Method.
+// Errors impl returns are sent to the host as CodeInternal unless they wrap
+// a *protocol.Error. The context impl is given is never cancelled, since
+// the plugin only reads the host's cancels between requests.
+func Register{{.Name}}(s *plugin.Server, impl {{.Name}}) {
+{{- range .Methods}}
+	s.Handle("{{$iface}}.{{.Name}}", func(req *plugin.Request) ([]byte, error) {
+		var arg {{.Arg}}
+		if err := json.Unmarshal(req.Payload, &arg); err != nil {
+			return nil, protocol.Errorf(protocol.CodeMalformed, "{{$iface}}.{{.Name}} argument: %v", err)
+		}
+		result, err := impl.{{.Name}}(context.Background(), arg)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(result)
+	})
+{{- end}}
+}
+{{end}}`))
@@ -0,0 +1,117 @@
+package ipcgen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+// parseSource parses src as the only file of a package.
+func parseSource(t *testing.T, src string, types ...string) (*Package, error) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseFiles(fset, []*ast.File{f}, types)
+}
+
+func TestParse(t *testing.T) {
+	pkg, err := parseSource(t, `package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	t "time"
+
+	"example.com/geo"
+)
+
+type Store interface {
+	Get(ctx context.Context, key string) (json.RawMessage, error)
+	Touch(context.Context, map[string]t.Time) (*netip.Addr, error)
+	Near(ctx context.Context, p geo.Point) ([]string, error)
+}
+`, "Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "store" || len(pkg.Interfaces) != 1 {
+		t.Fatalf("Unexpected package %+v", pkg)
+	}
+	want := []Method{
+		{Name: "Get", ArgName: "key", Arg: "string", Result: "json.RawMessage"},
+		{Name: "Touch", ArgName: "arg", Arg: "map[string]t.Time", Result: "*netip.Addr"},
+		{Name: "Near", ArgName: "p", Arg: "geo.Point", Result: "[]string"},
+	}
+	for i, m := range pkg.Interfaces[0].Methods {
+		if m != want[i] {
+			t.Errorf("Method %d: got %+v, want %+v", i, m, want[i])
+		}
+	}
+	// encoding/json is always imported by generated code
+	if got := strings.Join(pkg.Imports, " "); got != `"example.com/geo" "net/netip" t "time"` {
+		t.Errorf("Imports: got %s", got)
+	}
+
+	src, err := Generate(pkg, "goipcgen -type Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"func (c *StoreClient) Get(ctx context.Context, key string) (json.RawMessage, error) {",
+		`c.caller.Call(ctx, "Store.Touch", payload)`,
+		"func RegisterStore(s *plugin.Server, impl Store) {",
+		// Standard library imports share the first group
+		"import (\n\t\"context\"\n\t\"encoding/json\"\n\t\"net/netip\"\n\tt \"time\"\n\n\t\"example.com/geo\"\n\t\"github.com/jackc/goipcbench/plugin\"\n",
+	} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("Generated code lacks %q:\n%s", want, src)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		decl, want string
+	}{
+		{"type S interface{ M(key string) (string, error) }", "must take a context.Context and one argument"},
+		{"type S interface{ M(ctx context.Context, a, b string) (string, error) }", "must take a context.Context and one argument"},
+		{"type S interface{ M(ctx context.Context, a string) error }", "must return one result and an error"},
+		{"type S interface{ M(ctx context.Context, a string) (string, bool) }", "must return one result and an error"},
+		{"type S interface{ fmt.Stringer }", "embedded interfaces are not supported"},
+		{"type S struct{}", "not an interface"},
+		{"type T interface{}", "type S not found"},
+	} {
+		_, err := parseSource(t, "package p\n\nimport \"context\"\n\n"+tt.decl+"\n", "S")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.decl, err, tt.want)
+		}
+	}
+}
+
+// TestGeneratedUpToDate checks the checked in example matches what the
+// generator makes of it now.
+func TestGeneratedUpToDate(t *testing.T) {
+	pkg, err := Parse("../plugins/kv", []string{"KV"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(pkg, "goipcgen -type KV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := os.ReadFile("../plugins/kv/kv_ipc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, current) {
+		t.Error("plugins/kv/kv_ipc.go is out of date; run go generate ./plugins/kv")
+	}
+}
@@ -0,0 +1,13 @@
+// Command kv is a plugin serving an in-memory kv.Store through the
+// generated kv.RegisterKV.
+package main
+
+import (
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/plugins/kv"
+)
+
+func main() {
+	kv.RegisterKV(plugin.DefaultServer, kv.NewStore())
+	plugin.Serve()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/plugins/kv"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// TestKV calls the kv plugin through its generated client.
+func TestKV(t *testing.T) {
+	p := startPlugin(t, "kv", host.Launch, host.LaunchOptions{Transport: "unix"})
+	client := kv.NewKVClient(p)
+	ctx := context.Background()
+
+	start := time.Now()
+	if replaced, err := client.Set(ctx, kv.Entry{Key: "color", Value: []byte("blue")}); err != nil || replaced {
+		t.Fatalf("Set: got %v, %v", replaced, err)
+	}
+	if replaced, err := client.Set(ctx, kv.Entry{Key: "color", Value: []byte("green")}); err != nil || !replaced {
+		t.Fatalf("Set again: got %v, %v", replaced, err)
+	}
+
+	entry, err := client.Get(ctx, "color")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Found || string(entry.Value) != "green" || entry.Modified.Before(start) {
+		t.Errorf("Get: unexpected %+v", entry)
+	}
+	if entry, err := client.Get(ctx, "shape"); err != nil || entry.Found {
+		t.Errorf("Get missing key: got %+v, %v", entry, err)
+	}
+
+	keys, err := client.Keys(ctx, start)
+	if err != nil || !slices.Equal(keys, []string{"color"}) {
+		t.Errorf("Keys: got %v, %v", keys, err)
+	}
+
+	// The generated skeleton rejects an argument of the wrong type
+	_, err = p.Call(ctx, "KV.Get", []byte("not json"))
+	expectError(t, err, protocol.CodeMalformed)
+
+	p.shutdown(t)
+}
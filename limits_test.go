@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jackc/goipcbench/host"
+)
+
+// TestLimits runs the example plugin under resource limits over each
+// transport.
+func TestLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	limits := host.Limits{AddressSpace: 4 << 30, OpenFiles: 64, CPUSeconds: 60, NoCore: true}
+	for _, transport := range host.Transports {
+		t.Run(transport, func(t *testing.T) {
+			p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Transport: transport, Limits: limits})
+			data, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", p.Cmd.Process.Pid))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{
+				"Max address space         4294967296           4294967296           bytes",
+				"Max open files            64                   64                   files",
+				"Max cpu time              60                   60                   seconds",
+				"Max core file size        0                    0                    bytes",
+			} {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("Plugin limits do not contain %q:\n%s", want, data)
+				}
+			}
+			if resp := p.call(t, "ping", nil); string(resp) != "pong" {
+				t.Errorf("ping: got %q", resp)
+			}
+		})
+	}
+}
+
+// TestLimitsExceeded exceeds each limit and checks the plugin fails as it
+// should, saying why if it dies.
+func TestLimitsExceeded(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+
+	t.Run("open_files", func(t *testing.T) {
+		p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Limits: host.Limits{OpenFiles: 32}})
+		p.call(t, "open", []byte("8"))
+		_, err := p.Call(context.Background(), "open", []byte("64"))
+		if err == nil || !strings.Contains(err.Error(), "too many open files") {
+			t.Errorf("open 64: got %v", err)
+		}
+		p.call(t, "open", []byte("8"))
+	})
+
+	for _, tt := range []struct {
+		limits  host.Limits
+		method  string
+		payload string
+		want    string
+	}{
+		{host.Limits{CPUSeconds: 1}, "spin", "1m", host.LimitCPUSeconds},
+		{host.Limits{AddressSpace: 1 << 30}, "alloc", fmt.Sprint(int64(2) << 30), host.LimitAddressSpace},
+	} {
+		t.Run(tt.want, func(t *testing.T) {
+			p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Limits: tt.limits})
+			_, err := p.Call(context.Background(), tt.method, []byte(tt.payload))
+			var exitErr *host.ExitError
+			if !errors.As(err, &exitErr) || exitErr.Exceeded != tt.want {
+				t.Fatalf("%s: got %v, want plugin killed for exceeding %s", tt.method, err, tt.want)
+			}
+			if !strings.Contains(err.Error(), "exceeded its "+tt.want+" limit") {
+				t.Errorf("Error does not say which limit was exceeded: %v", err)
+			}
+		})
+	}
+
+	// A plugin that dies otherwise is not blamed on its limits
+	t.Run("other", func(t *testing.T) {
+		p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Limits: host.Limits{CPUSeconds: 60, AddressSpace: 4 << 30}})
+		p.Kill()
+		<-p.Exited()
+		if err := p.Err(); err == nil || err.Exceeded != "" {
+			t.Errorf("Killed plugin: got %v", err)
+		}
+	})
+}
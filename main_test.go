@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/bench"
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/profile"
+)
+
+// manifestDir holds the manifests of the plugins the tests start.
+const manifestDir = "manifests"
+
+// Flags for building the plugins, for example to benchmark them with
+// different optimisations:
+//
+//	go test -bench=. -plugin.gcflags=all=-N
+var (
+	pluginRace    = flag.Bool("plugin.race", false, "build plugins with the race detector")
+	pluginGcflags = flag.String("plugin.gcflags", "", "gcflags to build plugins with")
+	pluginLdflags = flag.String("plugin.ldflags", "", "ldflags to build plugins with")
+	pluginTags    = flag.String("plugin.tags", "", "build tags to build plugins with")
+)
+
+// Flags for profiling the plugins, and the test binary alongside them:
+//
+//	go test -bench=Unix -plugin.profile
+//	go tool pprof goipcbench-plugins/unix.1234.cpu.pprof
+var (
+	pluginProfile    = flag.Bool("plugin.profile", false, "write profiles and traces of the plugins and host to a kept temporary directory")
+	pluginProfileDir = flag.String("plugin.profiledir", "", "write profiles and traces of the plugins and host to `dir`")
+)
+
+// profileDir is where plugins write profiles, or empty if they do not.
+var profileDir string
+
+// benchWarmup is how long benchmarks call a plugin before timing it, so the
+// first iterations do not include page faults and cold buffers.
+var benchWarmup = flag.Duration("bench.warmup", 100*time.Millisecond, "how long to call a plugin before timing a benchmark")
+
+// builtPlugins maps the name of each plugin in manifestDir to its binary or
+// build failure.
+var builtPlugins = map[string]builtPlugin{}
+
+type builtPlugin struct {
+	path string
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "goipcbench-plugins")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create plugin directory: %v\n", err)
+		os.Exit(1)
+	}
+	// A sandbox can only restrict every thread of a plugin built without
+	// cgo, which the race detector needs
+	if !*pluginRace {
+		os.Setenv("CGO_ENABLED", "0")
+	}
+	if err := buildPlugins(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to discover plugins: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := startProfiling(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start profiling: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Record the environment ahead of benchmark results, as go test does
+	// for goos, goarch and cpu
+	if f := flag.Lookup("test.bench"); f != nil && f.Value.String() != "" {
+		bench.WriteConfig(os.Stdout, bench.CaptureEnv())
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	stopProfiling()
+	os.Exit(code)
+}
+
+// stopProfiling stops profiling the host, if it is.
+var stopProfiling = func() {}
+
+// startProfiling sets profileDir from the profiling flags and starts
+// profiling the host into it.
+func startProfiling() error {
+	profileDir = *pluginProfileDir
+	if profileDir == "" && *pluginProfile {
+		var err error
+		if profileDir, err = os.MkdirTemp("", "goipcbench-profiles-"); err != nil {
+			return err
+		}
+	}
+	if profileDir == "" {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Writing profiles to %s\n", profileDir)
+
+	stop, err := profile.Start(profileDir, "host")
+	if err != nil {
+		// go test's own -cpuprofile or -trace may already be running, in
+		// which case only the plugins are profiled here
+		fmt.Fprintf(os.Stderr, "Not profiling the host: %v\n", err)
+		return nil
+	}
+	stopProfiling = func() {
+		if err := stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write host profiles: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// buildPlugins builds all of the plugins in manifestDir into dir in
+// parallel.
+func buildPlugins(dir string) error {
+	manifests, err := host.Discover(manifestDir)
+	if err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, m := range manifests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := filepath.Join(dir, m.Name+"-plugin")
+			err := m.Build(context.Background(), path, buildFlags()...)
+			mu.Lock()
+			builtPlugins[m.Name] = builtPlugin{path: path, err: err}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// buildFlags returns the go build flags given on the command line.
+func buildFlags() []string {
+	var flags []string
+	if *pluginRace {
+		flags = append(flags, "-race")
+	}
+	if *pluginGcflags != "" {
+		flags = append(flags, "-gcflags", *pluginGcflags)
+	}
+	if *pluginLdflags != "" {
+		flags = append(flags, "-ldflags", *pluginLdflags)
+	}
+	if *pluginTags != "" {
+		flags = append(flags, "-tags", *pluginTags)
+	}
+	return flags
+}
+
+// pluginBinary returns the path of the binary built for the plugin named
+// name in manifestDir.
+func pluginBinary(tb testing.TB, name string) string {
+	tb.Helper()
+	built, ok := builtPlugins[name]
+	if !ok {
+		tb.Fatalf("Plugin %s is not built by TestMain", name)
+	}
+	if built.err != nil {
+		tb.Fatalf("Failed to build plugin: %v", built.err)
+	}
+	return built.path
+}
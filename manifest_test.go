@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/goipcbench/host"
+)
+
+// TestLaunchDir launches every plugin in manifestDir on the transport its
+// manifest prefers. The manifests are copied to name the binaries TestMain
+// built rather than building them again.
+func TestLaunchDir(t *testing.T) {
+	manifests, err := host.Discover(manifestDir)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	dir := t.TempDir()
+	for _, m := range manifests {
+		m.Binary, m.Package = pluginBinary(t, m.Name), ""
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, m.Name+".json"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	plugins, err := host.LaunchDir(ctx, dir, launchOptions(host.LaunchOptions{}))
+	for _, p := range plugins {
+		defer p.Close()
+	}
+	if err != nil {
+		t.Fatalf("LaunchDir: %v", err)
+	}
+	if len(plugins) != len(manifests) {
+		t.Errorf("LaunchDir: got %d plugins, want %d", len(plugins), len(manifests))
+	}
+	for name, p := range plugins {
+		if p.Client.Hello() == nil {
+			t.Errorf("Plugin %s did not say hello", name)
+		}
+	}
+}
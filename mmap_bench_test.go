@@ -1,229 +1,96 @@
 package main
 
 import (
-	"os"
-	"os/exec"
-	"path/filepath"
-	"sync/atomic"
-	"syscall"
 	"testing"
-	"time"
-	"unsafe"
-)
 
-const (
-	pageSize = 4096
-	cmdOffset = 0
-	msgOffset = 64
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/protocol"
 )
 
-func BenchmarkMmap(b *testing.B) {
-	// Create temporary directory for plugin binary and shared memory
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		b.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "mmap-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./mmap")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		b.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
-
-	// Create shared memory file
-	shmPath := filepath.Join(tmpDir, "shared.mem")
-	shmFile, err := os.Create(shmPath)
-	if err != nil {
-		b.Fatalf("Failed to create shared memory file: %v", err)
-	}
-
-	// Resize file to page size
-	if err := shmFile.Truncate(pageSize); err != nil {
-		b.Fatalf("Failed to resize shared memory file: %v", err)
-	}
-
-	// Memory map the file
-	data, err := syscall.Mmap(int(shmFile.Fd()), 0, pageSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
-	if err != nil {
-		b.Fatalf("Failed to mmap file: %v", err)
-	}
-	defer syscall.Munmap(data)
-	shmFile.Close()
+// startMmapPlugin starts the shared memory plugin. The host waits
+// for replies using wait.
+func startMmapPlugin(tb testing.TB, wait protocol.WaitStrategy) *plugin {
+	return startPlugin(tb, "mmap", host.StartMmap, host.LaunchOptions{Wait: wait})
+}
 
-	// Initialize shared memory to zeros
-	for i := range data {
-		data[i] = 0
-	}
+func BenchmarkMmap(b *testing.B) {
+	p := startMmapPlugin(b, protocol.WaitSpin)
+	benchmarkPingPong(b, p)
+	p.shutdown(b)
+}
 
-	// Create atomic pointer to the command byte
-	cmdPtr := (*uint32)(unsafe.Pointer(&data[cmdOffset]))
+func BenchmarkMmapCallback(b *testing.B) {
+	p := startMmapPlugin(b, protocol.WaitSpin)
+	benchmarkCallback(b, p)
+	p.shutdown(b)
+}
 
-	// Start the plugin process
-	cmd := exec.Command(pluginPath, shmPath)
-	if err := cmd.Start(); err != nil {
-		b.Fatalf("Failed to start plugin: %v", err)
-	}
+func BenchmarkMmapStream(b *testing.B) {
+	p := startMmapPlugin(b, protocol.WaitSpin)
+	benchmarkStream(b, p)
+	p.shutdown(b)
+}
 
-	// Wait for plugin to be ready
-	for i := 0; i < 100; i++ {
-		if atomic.LoadUint32(cmdPtr) == 1 {
-			msgBytes := data[msgOffset:msgOffset+32]
-			if string(msgBytes[:5]) == "ready" {
-				break
-			}
-		}
-		time.Sleep(10 * time.Millisecond)
-		if i == 99 {
-			b.Fatalf("Plugin did not signal ready")
-		}
-	}
+func BenchmarkMmapRecovery(b *testing.B) {
+	benchmarkRecovery(b, "mmap", host.StartMmap, host.LaunchOptions{Wait: protocol.WaitSpin})
+}
 
-	// Reset timer after setup
-	b.ResetTimer()
-
-	// Run benchmark
-	for i := 0; i < b.N; i++ {
-		// Write ping command
-		copy(data[msgOffset:], []byte("ping\x00"))
-		// Signal command ready
-		atomic.StoreUint32(cmdPtr, 2)
-
-		// Wait for response
-		for atomic.LoadUint32(cmdPtr) != 1 {
-			// Busy wait
-		}
-
-		// Verify response
-		msgBytes := data[msgOffset:msgOffset+32]
-		if string(msgBytes[:4]) != "pong" {
-			b.Fatalf("Unexpected response: %s", string(msgBytes[:4]))
-		}
-	}
+func BenchmarkMmapPlacement(b *testing.B) {
+	benchmarkPlacement(b, "mmap", host.StartMmap, host.LaunchOptions{Wait: protocol.WaitSpin})
+}
 
-	b.StopTimer()
-
-	// Cleanup: send quit command
-	copy(data[msgOffset:], []byte("quit\x00"))
-	atomic.StoreUint32(cmdPtr, 2)
-
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			b.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		b.Errorf("Plugin process did not exit after quit command")
-	}
+func BenchmarkMmapSandbox(b *testing.B) {
+	benchmarkSandbox(b, "mmap", host.StartMmap, host.LaunchOptions{Wait: protocol.WaitSpin})
 }
 
 func TestMmapPingPong(t *testing.T) {
-	// Create temporary directory for plugin binary and shared memory
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "mmap-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./mmap")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
-
-	// Create shared memory file
-	shmPath := filepath.Join(tmpDir, "shared.mem")
-	shmFile, err := os.Create(shmPath)
-	if err != nil {
-		t.Fatalf("Failed to create shared memory file: %v", err)
-	}
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testPingPong(t, p)
+	p.shutdown(t)
+}
 
-	// Resize file to page size
-	if err := shmFile.Truncate(pageSize); err != nil {
-		t.Fatalf("Failed to resize shared memory file: %v", err)
-	}
+func TestMmapHandshake(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testHandshake(t, p, "mmap", protocol.MaxMmapPayload)
+	p.shutdown(t)
+}
 
-	// Memory map the file
-	data, err := syscall.Mmap(int(shmFile.Fd()), 0, pageSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
-	if err != nil {
-		t.Fatalf("Failed to mmap file: %v", err)
-	}
-	defer syscall.Munmap(data)
-	shmFile.Close()
+func TestMmapTimeout(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testTimeout(t, p)
+	p.shutdown(t)
+}
 
-	// Initialize shared memory to zeros
-	for i := range data {
-		data[i] = 0
-	}
+func TestMmapCallback(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testCallback(t, p)
+	p.shutdown(t)
+}
 
-	// Create atomic pointer to the command byte
-	cmdPtr := (*uint32)(unsafe.Pointer(&data[cmdOffset]))
+func TestMmapStream(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testStream(t, p)
+	p.shutdown(t)
+}
 
-	// Start the plugin process
-	cmd := exec.Command(pluginPath, shmPath)
-	if err := cmd.Start(); err != nil {
-		t.Fatalf("Failed to start plugin: %v", err)
-	}
+func TestMmapErrors(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testErrors(t, p)
+	p.shutdown(t)
+}
 
-	// Wait for plugin to be ready
-	for i := 0; i < 100; i++ {
-		if atomic.LoadUint32(cmdPtr) == 1 {
-			msgBytes := data[msgOffset:msgOffset+32]
-			if string(msgBytes[:5]) == "ready" {
-				break
-			}
-		}
-		time.Sleep(10 * time.Millisecond)
-		if i == 99 {
-			t.Fatalf("Plugin did not signal ready")
-		}
-	}
+func TestMmapShutdown(t *testing.T) {
+	p := startMmapPlugin(t, protocol.WaitSleep)
+	testShutdown(t, p)
+}
 
-	// Test ping/pong
-	for i := 0; i < 5; i++ {
-		// Write ping command
-		copy(data[msgOffset:], []byte("ping\x00"))
-		// Signal command ready
-		atomic.StoreUint32(cmdPtr, 2)
-
-		// Wait for response
-		for atomic.LoadUint32(cmdPtr) != 1 {
-			time.Sleep(100 * time.Nanosecond)
-		}
-
-		// Verify response
-		msgBytes := data[msgOffset:msgOffset+32]
-		if string(msgBytes[:4]) != "pong" {
-			t.Fatalf("Unexpected response: %s", string(msgBytes[:4]))
-		}
+func TestMmapTerminate(t *testing.T) {
+	for _, busy := range []bool{false, true} {
+		p := startMmapPlugin(t, protocol.WaitSleep)
+		testTerminate(t, p, busy)
 	}
+}
 
-	// Test quit command
-	copy(data[msgOffset:], []byte("quit\x00"))
-	atomic.StoreUint32(cmdPtr, 2)
-
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			t.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		t.Errorf("Plugin process did not exit after quit command")
-	}
-}
\ No newline at end of file
+func TestMmapCrash(t *testing.T) {
+	testCrash(t, "mmap", host.StartMmap, host.LaunchOptions{Wait: protocol.WaitSleep})
+}
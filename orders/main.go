@@ -0,0 +1,14 @@
+// Command orders is a plugin serving package orders, with payloads read in
+// place from the shared memory region when it runs over mmap.
+package main
+
+import (
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/plugins/orders"
+)
+
+func main() {
+	orders.Register(plugin.DefaultServer)
+	plugin.DefaultServer.SetInPlace(true)
+	plugin.Serve()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/goipcbench/codec"
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/plugins/orders"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// orderItems are the order sizes BenchmarkOrders sends. The largest fits in
+// the mmap region in every codec.
+var orderItems = []int{1, 8, 32}
+
+// callOrder sends o to the orders plugin in c, reusing buf for the
+// encoding, and returns the decoded receipt.
+func callOrder(tb testing.TB, p *plugin, c codec.Codec, o *orders.Order, buf []byte) (orders.Receipt, []byte) {
+	tb.Helper()
+	buf, err := c.Append(buf[:0], o)
+	if err != nil {
+		tb.Fatalf("Failed to encode order: %v", err)
+	}
+	var r orders.Receipt
+	if err := c.Unmarshal(p.call(tb, "order."+c.Name(), buf), &r); err != nil {
+		tb.Fatalf("Failed to decode receipt: %v", err)
+	}
+	return r, buf
+}
+
+// TestOrders sends orders in each codec over each transport.
+func TestOrders(t *testing.T) {
+	for _, transport := range host.Transports {
+		t.Run(transport, func(t *testing.T) {
+			p := startPlugin(t, "orders", host.Launch, host.LaunchOptions{Transport: transport})
+			for _, c := range codec.All {
+				for _, items := range orderItems {
+					o := orders.NewOrder(uint64(items), items)
+					if r, _ := callOrder(t, p, c, o, nil); r != o.Receipt() {
+						t.Errorf("%s with %d items: got %+v, want %+v", c.Name(), items, r, o.Receipt())
+					}
+				}
+
+				// The plugin reports orders it cannot decode
+				_, err := p.Call(context.Background(), "order."+c.Name(), []byte{0xff})
+				expectError(t, err, protocol.CodeMalformed)
+			}
+			p.shutdown(t)
+		})
+	}
+}
+
+// BenchmarkOrders sends orders over each transport in each codec, so the
+// cost of serialization can be set against the cost of the transport. The
+// host encodes each order and decodes the receipt, and the plugin decodes
+// the order and encodes the receipt. Over mmap the plugin reads payloads in
+// place, which the flat codec takes advantage of. Benchmarks in
+// plugins/orders measure the codecs alone.
+func BenchmarkOrders(b *testing.B) {
+	for _, transport := range host.Transports {
+		b.Run(transport, func(b *testing.B) {
+			p := startPlugin(b, "orders", host.Launch, host.LaunchOptions{Transport: transport, Wait: protocol.WaitSpin})
+			for _, c := range codec.All {
+				for _, items := range orderItems {
+					b.Run(fmt.Sprintf("%s/items=%d", c.Name(), items), func(b *testing.B) {
+						benchmarkOrder(b, p, c, orders.NewOrder(1, items))
+					})
+				}
+			}
+			p.shutdown(b)
+		})
+	}
+}
+
+func benchmarkOrder(b *testing.B, p *plugin, c codec.Codec, o *orders.Order) {
+	var buf []byte
+	warmUp(func() { _, buf = callOrder(b, p, c, o, buf) })
+	cost := measureCost(b, p)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, buf = callOrder(b, p, c, o, buf)
+	}
+	b.StopTimer()
+	cost.report(b)
+	b.ReportMetric(float64(len(buf)), "req-bytes")
+}
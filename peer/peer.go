@@ -0,0 +1,98 @@
+// Package peer authenticates the process at the other end of a connection
+// between a host and a plugin, so that another local process cannot take
+// the host's place or the plugin's. Over Unix domain sockets the kernel
+// says which process is connected. Over TCP it cannot, so the host proves
+// itself with a one-time token it gave the plugin when starting it.
+package peer
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"time"
+)
+
+// ErrUnsupported is returned on systems where the process at the other end
+// of a Unix domain socket cannot be found out.
+var ErrUnsupported = errors.New("peer credentials are not supported on " + runtime.GOOS)
+
+// Cred is who is at the other end of a Unix domain socket: the process that
+// connected it, or that was listening for the connection.
+type Cred struct {
+	PID int
+	UID int
+}
+
+// Unix returns who is at the other end of conn.
+func Unix(conn *net.UnixConn) (Cred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var cred Cred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) { cred, credErr = peerCred(int(fd)) }); err != nil {
+		return Cred{}, err
+	}
+	return cred, credErr
+}
+
+// Check returns an error unless the process at the other end of conn, a
+// Unix domain socket, is pid and run by the same user as this process.
+func Check(conn net.Conn, pid int) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("cannot check the peer of a %T", conn)
+	}
+	cred, err := Unix(unixConn)
+	if err != nil {
+		return fmt.Errorf("peer credentials: %w", err)
+	}
+	if cred.UID != os.Getuid() {
+		return fmt.Errorf("peer is run by user %d, not %d", cred.UID, os.Getuid())
+	}
+	if cred.PID != pid {
+		return fmt.Errorf("peer is process %d, not %d", cred.PID, pid)
+	}
+	return nil
+}
+
+// TokenSize is the length of a token in bytes.
+const TokenSize = 32
+
+// NewToken returns a new random token.
+func NewToken() []byte {
+	token := make([]byte, TokenSize)
+	rand.Read(token)
+	return token
+}
+
+// ReadToken reads a token from r, such as the pipe a plugin was handed it
+// on.
+func ReadToken(r io.Reader) ([]byte, error) {
+	token := make([]byte, TokenSize)
+	if _, err := io.ReadFull(r, token); err != nil {
+		return nil, fmt.Errorf("read token: %w", err)
+	}
+	return token, nil
+}
+
+// VerifyToken returns an error unless the first thing the other end of conn
+// sends, within timeout, is token.
+func VerifyToken(conn net.Conn, token []byte, timeout time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	got, err := ReadToken(conn)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, token) != 1 {
+		return errors.New("wrong token")
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package peer
+
+import "syscall"
+
+func peerCred(fd int) (Cred, error) {
+	ucred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return Cred{}, err
+	}
+	return Cred{PID: int(ucred.Pid), UID: int(ucred.Uid)}, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package peer
+
+func peerCred(fd int) (Cred, error) {
+	return Cred{}, ErrUnsupported
+}
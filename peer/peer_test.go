@@ -0,0 +1,97 @@
+package peer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unixPair returns both ends of a Unix domain socket connection.
+func unixPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "peer.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err = net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestCheck(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+	client, server := unixPair(t)
+	for _, conn := range []net.Conn{client, server} {
+		cred, err := Unix(conn.(*net.UnixConn))
+		if err != nil || cred.PID != os.Getpid() || cred.UID != os.Getuid() {
+			t.Errorf("Unix: got %+v, %v", cred, err)
+		}
+		if err := Check(conn, os.Getpid()); err != nil {
+			t.Errorf("Check: %v", err)
+		}
+		if err := Check(conn, os.Getppid()); err == nil || !strings.Contains(err.Error(), "peer is process") {
+			t.Errorf("Check of the wrong process: got %v", err)
+		}
+	}
+
+	tcpConn, _ := net.Pipe()
+	if err := Check(tcpConn, os.Getpid()); err == nil {
+		t.Errorf("Check of a pipe succeeded")
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	token := NewToken()
+	if len(token) != TokenSize || bytes.Equal(token, NewToken()) {
+		t.Fatalf("NewToken: got %x", token)
+	}
+
+	for _, tt := range []struct {
+		name string
+		sent []byte
+		err  string
+	}{
+		{"right", token, ""},
+		{"wrong", NewToken(), "wrong token"},
+		{"short", token[:10], "timeout"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write(tt.sent)
+			err := VerifyToken(server, token, 50*time.Millisecond)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("VerifyToken: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("VerifyToken: got %v, want error containing %s", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := ReadToken(strings.NewReader("short")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadToken of a short token: got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+// Package plugin serves a plugin's methods to the host, so a plugin only
+// registers handlers and calls Serve. The host picks the transport, which
+// reaches the plugin in the environment or its arguments.
+//
+//	func main() {
+//		plugin.Handle("echo", func(req *plugin.Request) ([]byte, error) {
+//			return req.Payload, nil
+//		})
+//		plugin.Serve()
+//	}
+package plugin
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/jackc/goipcbench/profile"
+	"github.com/jackc/goipcbench/protocol"
+	"github.com/jackc/goipcbench/sandbox"
+)
+
+// EnvTransport is the environment variable the host names the transport in
+// when it starts a plugin: "stdio", "tcp", "unix" or "mmap".
+const EnvTransport = "GOIPCBENCH_TRANSPORT"
+
+// EnvTokenFD is the environment variable naming the descriptor the host
+// hands a tcp plugin a one-time token on, which the host then presents
+// when it connects. See package peer.
+const EnvTokenFD = "GOIPCBENCH_TOKEN_FD"
+
+// EnvSandbox is the environment variable the host sets to a non-empty value
+// to have the plugin sandbox itself once its transport is connected, with
+// the policy package sandbox has for the transport.
+const EnvSandbox = "GOIPCBENCH_SANDBOX"
+
+// HandlerFunc answers a request. A returned *protocol.Error is sent to the
+// host with its code and any other error as CodeInternal.
+type HandlerFunc func(req *Request) ([]byte, error)
+
+// Request is a call from the host.
+type Request struct {
+	Method string
+	// Payload is only valid until the handler returns, calls the host or
+	// sends a chunk if the Server reads payloads in place.
+	Payload []byte
+
+	conn protocol.Conn
+	id   uint32
+
+	// stream records that the host asked for the reply to be streamed, and
+	// credits how many more chunks it will take.
+	stream  bool
+	credits uint32
+}
+
+// Call calls method on the host while serving the request and returns the
+// response payload. If the host replies with an error it is returned as a
+// *protocol.Error, and if the host cancels the request Call returns an error
+// with CodeCanceled.
+func (r *Request) Call(method string, payload []byte) ([]byte, error) {
+	conn := r.conn
+	if r.stream {
+		// Keep credits the host grants during the call
+		conn = creditConn{req: r}
+	}
+	msg, err := protocol.Call(conn, &protocol.Message{Type: protocol.TypeRequest, ID: r.id, Method: method, Payload: payload}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Payload, nil
+}
+
+// Server serves registered handlers over one transport. Its zero value is
+// not usable; create one with New.
+type Server struct {
+	name     string
+	handlers map[string]HandlerFunc
+	streams  map[string]StreamFunc
+	methods  []string
+	inPlace  bool
+}
+
+// New returns a Server that introduces itself to the host as name.
+func New(name string) *Server {
+	return &Server{name: name, handlers: map[string]HandlerFunc{}, streams: map[string]StreamFunc{}}
+}
+
+// Handle registers h to answer calls to method, replacing any handler
+// registered for it before. Handlers must be registered before serving.
+func (s *Server) Handle(method string, h HandlerFunc) {
+	s.addMethod(method)
+	s.handlers[method] = h
+}
+
+// addMethod records method for the hello and forgets any handler registered
+// for it before.
+func (s *Server) addMethod(method string) {
+	if !slices.Contains(s.methods, method) {
+		s.methods = append(s.methods, method)
+	}
+	delete(s.handlers, method)
+	delete(s.streams, method)
+}
+
+// SetInPlace makes handlers served over the mmap transport get payloads
+// that alias the shared memory region rather than copies, for payloads laid
+// out to be read where they are. Other transports copy them regardless.
+func (s *Server) SetInPlace(inPlace bool) {
+	s.inPlace = inPlace
+}
+
+// Serve serves the transport chosen by the host with the command line
+// arguments args, without the program name. The transport is the
+// -transport flag if given, or else EnvTransport, or else stdio. The
+// arguments left after the flag are the transport's own, such as the port
+// for tcp.
+//
+// Serve returns nil once the host has shut the plugin down, or it has been
+// stopped with SIGTERM after finishing the request in progress.
+func (s *Server) Serve(args []string) error {
+	flags := flag.NewFlagSet(s.name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	transport := flags.String("transport", os.Getenv(EnvTransport), "transport to serve: stdio, tcp, unix or mmap")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *transport == "" {
+		*transport = "stdio"
+	}
+	return s.ServeTransport(*transport, flags.Args())
+}
+
+// ServeTransport serves transport with its arguments args, as Serve does
+// once it has picked the transport.
+//
+// If the host asks for a sandbox the plugin applies it after connecting,
+// and returns an error rather than serving without it.
+func (s *Server) ServeTransport(transport string, args []string) error {
+	listen, ok := transports[transport]
+	if !ok {
+		return fmt.Errorf("unknown transport %q", transport)
+	}
+	sandboxed := os.Getenv(EnvSandbox) != ""
+	if sandboxed && os.Getenv(profile.EnvDir) != "" {
+		return errors.New("a sandboxed plugin cannot write profiles")
+	}
+
+	// Profile the plugin when the host asks for it
+	defer profile.FromEnv(s.name)()
+
+	conn, maxPayload, closeConn, err := listen(args)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	// Finish the request in progress and clean up when asked to terminate
+	stop := protocol.NewStopper(conn)
+	stop.Notify(syscall.SIGTERM)
+
+	if sandboxed {
+		policy, err := sandbox.Transport(transport)
+		if err != nil {
+			return err
+		}
+		if err := sandbox.Apply(policy); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+	}
+	return s.serve(conn, maxPayload, stop, transport)
+}
+
+// ServeConn serves a connection to the host that carries at most maxPayload,
+// for a transport this package does not set up. It returns nil once the
+// host has shut the plugin down or closed the connection.
+func (s *Server) ServeConn(conn protocol.Conn, maxPayload uint32) error {
+	return s.serve(conn, maxPayload, protocol.NewStopper(conn), "conn")
+}
+
+// serve serves conn, which carries at most maxPayload, until the host shuts
+// the plugin down or stop stops it. transport names conn in errors.
+func (s *Server) serve(conn protocol.Conn, maxPayload uint32, stop *protocol.Stopper, transport string) error {
+	// Introduce ourselves and check the host can talk to us
+	hello := protocol.NewHello(s.name, maxPayload, slices.Clone(s.methods)...)
+	if _, err := protocol.HandshakePlugin(conn, hello); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	if mc, ok := conn.(*protocol.MmapConn); ok {
+		mc.SetInPlace(s.inPlace)
+	}
+
+	for {
+		msg, err := conn.ReadMessage()
+		if stop.Stopped(err) {
+			return nil
+		}
+		stop.Begin()
+
+		var reply *protocol.Message
+		shutdown := false
+		var perr *protocol.Error
+		switch {
+		case errors.As(err, &perr):
+			// Report undecodable messages rather than leave the host waiting
+			reply = protocol.ErrorMessage(0, perr)
+		case err != nil:
+			stop.End()
+			return fmt.Errorf("read %s: %w", transport, err)
+		case msg.Type == protocol.TypeCancel, msg.Type == protocol.TypeCredit:
+			// The request or stream already got its reply
+		case msg.Type == protocol.TypeShutdown:
+			// Acknowledge, then return so the deferred cleanup runs
+			reply = &protocol.Message{Type: protocol.TypeResponse, ID: msg.ID}
+			shutdown = true
+		case msg.Type == protocol.TypeStream:
+			reply = s.handleStream(conn, msg)
+		case msg.Type != protocol.TypeRequest:
+			reply = protocol.ErrorMessage(msg.ID, protocol.Errorf(protocol.CodeMalformed, "unexpected %v message", msg.Type))
+		default:
+			reply = s.handle(conn, msg)
+		}
+
+		if reply != nil {
+			if err := conn.WriteMessage(reply); err != nil {
+				stop.End()
+				return fmt.Errorf("write %s: %w", transport, err)
+			}
+		}
+		if stop.End() || shutdown {
+			return nil
+		}
+	}
+}
+
+// handle answers a request from the host with its handler.
+func (s *Server) handle(conn protocol.Conn, msg *protocol.Message) *protocol.Message {
+	h, ok := s.handlers[msg.Method]
+	if !ok {
+		if _, ok := s.streams[msg.Method]; ok {
+			return protocol.ErrorMessage(msg.ID, protocol.Errorf(protocol.CodeUnknownMethod, "%s streams its reply", msg.Method))
+		}
+		return protocol.ErrorMessage(msg.ID, protocol.Errorf(protocol.CodeUnknownMethod, "unknown method: %s", msg.Method))
+	}
+	payload, err := h(&Request{Method: msg.Method, Payload: msg.Payload, conn: conn, id: msg.ID})
+	if err != nil {
+		return protocol.ErrorMessage(msg.ID, err)
+	}
+	return &protocol.Message{Type: protocol.TypeResponse, ID: msg.ID, Payload: payload}
+}
+
+// DefaultServer is the Server used by Handle and Serve, named after the
+// program.
+var DefaultServer = New(filepath.Base(os.Args[0]))
+
+// Handle registers h to answer calls to method on DefaultServer.
+func Handle(method string, h HandlerFunc) {
+	DefaultServer.Handle(method, h)
+}
+
+// Serve serves DefaultServer with the program's arguments and exits with
+// status 1 if it fails.
+func Serve() {
+	if err := DefaultServer.Serve(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", DefaultServer.name, err)
+		os.Exit(1)
+	}
+}
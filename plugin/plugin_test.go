@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/peer"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+func TestServeTransport(t *testing.T) {
+	s := New("test")
+	if err := s.Serve([]string{"-transport", "pigeon"}); err == nil || !strings.Contains(err.Error(), "pigeon") {
+		t.Errorf("Expected unknown transport error, got %v", err)
+	}
+	t.Setenv(EnvTransport, "carrier")
+	if err := s.Serve(nil); err == nil || !strings.Contains(err.Error(), "carrier") {
+		t.Errorf("Expected the transport from the environment, got %v", err)
+	}
+	if err := s.Serve([]string{"-transport", "tcp"}); err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("Expected the flag to override the environment, got %v", err)
+	}
+	if err := s.Serve([]string{"-transport", "tcp", "0"}); err == nil || !strings.Contains(err.Error(), EnvTokenFD) {
+		t.Errorf("Expected tcp to need a token, got %v", err)
+	}
+}
+
+// TestAccept rejects connections that do not present the token, including
+// one that stalls, and accepts the one that does.
+func TestAccept(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	token := peer.NewToken()
+
+	dial := func(send []byte) net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.Write(send)
+		return conn
+	}
+	stalled := dial(nil)
+	wrong := dial(peer.NewToken())
+	right := dial(token)
+
+	conn, err := accept(listener, func(conn net.Conn) error {
+		return peer.VerifyToken(conn, token, time.Second)
+	})
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != right.LocalAddr().String() {
+		t.Errorf("Accepted %v, want %v", conn.RemoteAddr(), right.LocalAddr())
+	}
+	wrong.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := wrong.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Connection with the wrong token: got %v, want it closed", err)
+	}
+	stalled.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Stalled connection: got %v, want it closed", err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := New("test")
+	s.Handle("echo", func(req *Request) ([]byte, error) { return req.Payload, nil })
+	s.Handle("fail", func(*Request) ([]byte, error) { return nil, errors.New("broken") })
+	s.Handle("busy", func(*Request) ([]byte, error) { return nil, protocol.Errorf(protocol.CodeTooLarge, "too big") })
+	s.Handle("echo", func(req *Request) ([]byte, error) { return append([]byte("re:"), req.Payload...), nil })
+	if want := []string{"echo", "fail", "busy"}; strings.Join(s.methods, ",") != strings.Join(want, ",") {
+		t.Errorf("Methods: got %v, want %v", s.methods, want)
+	}
+
+	reply := s.handle(nil, &protocol.Message{Type: protocol.TypeRequest, ID: 7, Method: "echo", Payload: []byte("hi")})
+	if reply.Type != protocol.TypeResponse || reply.ID != 7 || string(reply.Payload) != "re:hi" {
+		t.Errorf("Unexpected reply to echo: %+v", reply)
+	}
+	for method, code := range map[string]protocol.Code{
+		"fail":  protocol.CodeInternal,
+		"busy":  protocol.CodeTooLarge,
+		"bogus": protocol.CodeUnknownMethod,
+	} {
+		reply := s.handle(nil, &protocol.Message{Type: protocol.TypeRequest, ID: 8, Method: method})
+		var perr *protocol.Error
+		if !errors.As(reply.Err(), &perr) || perr.Code != code || reply.ID != 8 {
+			t.Errorf("%s: got %+v, want error code %v", method, reply, code)
+		}
+	}
+}
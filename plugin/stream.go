@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// StreamFunc answers a request by sending any number of chunks with
+// req.Send. Returning nil ends the stream, and a returned error is sent to
+// the host as HandlerFunc's are.
+type StreamFunc func(req *Request) error
+
+// HandleStream registers h to stream its reply to calls to method,
+// replacing any handler registered for it before. The host must call method
+// as a stream.
+func (s *Server) HandleStream(method string, h StreamFunc) {
+	s.addMethod(method)
+	s.streams[method] = h
+}
+
+// HandleStream registers h to stream its reply to calls to method on
+// DefaultServer.
+func HandleStream(method string, h StreamFunc) {
+	DefaultServer.HandleStream(method, h)
+}
+
+// errNotStream is returned by Send for a request whose reply is not
+// streamed.
+var errNotStream = errors.New("send on a request that is not a stream")
+
+// Send sends chunk as the next part of the reply to a stream request. Once
+// the host has as many chunks as it has room for, Send waits for it to
+// receive some. If the host cancels the stream Send returns an error with
+// CodeCanceled.
+func (r *Request) Send(chunk []byte) error {
+	if !r.stream {
+		return errNotStream
+	}
+	for r.credits == 0 {
+		msg, err := r.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case protocol.TypeCredit:
+			r.credit(msg)
+		case protocol.TypeCancel:
+			// Cancels for anything else are stale
+			if msg.ID == r.id {
+				return protocol.Errorf(protocol.CodeCanceled, "%s canceled by host", r.Method)
+			}
+		default:
+			return protocol.Errorf(protocol.CodeMalformed, "unexpected %v message during stream", msg.Type)
+		}
+	}
+	r.credits--
+	return r.conn.WriteMessage(&protocol.Message{Type: protocol.TypeChunk, ID: r.id, Payload: chunk})
+}
+
+// credit adds the credits granted by msg if they are for r. Credits for
+// another stream are stale.
+func (r *Request) credit(msg *protocol.Message) {
+	if n, err := msg.Credits(); err == nil && msg.ID == r.id {
+		r.credits += n
+	}
+}
+
+// handleStream answers a stream request from the host with its handler. The
+// reply is the end marker or error that follows the chunks.
+func (s *Server) handleStream(conn protocol.Conn, msg *protocol.Message) *protocol.Message {
+	h, ok := s.streams[msg.Method]
+	if !ok {
+		return protocol.ErrorMessage(msg.ID, protocol.Errorf(protocol.CodeUnknownMethod, "unknown stream method: %s", msg.Method))
+	}
+	req := &Request{Method: msg.Method, Payload: msg.Payload, conn: conn, id: msg.ID, stream: true}
+	if err := h(req); err != nil {
+		return protocol.ErrorMessage(msg.ID, err)
+	}
+	return &protocol.Message{Type: protocol.TypeResponse, ID: msg.ID}
+}
+
+// creditConn reads the connection of a stream request, taking the credits
+// the host grants for it out of what is read.
+type creditConn struct {
+	req *Request
+}
+
+func (c creditConn) ReadMessage() (*protocol.Message, error) {
+	for {
+		msg, err := c.req.conn.ReadMessage()
+		if err != nil || msg.Type != protocol.TypeCredit {
+			return msg, err
+		}
+		c.req.credit(msg)
+	}
+}
+
+func (c creditConn) WriteMessage(msg *protocol.Message) error {
+	return c.req.conn.WriteMessage(msg)
+}
+
+func (c creditConn) SetDeadline(t time.Time) error {
+	return c.req.conn.SetDeadline(t)
+}
+
+func (c creditConn) Close() error {
+	return c.req.conn.Close()
+}
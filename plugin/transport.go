@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jackc/goipcbench/peer"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// listenFunc sets up a transport from its arguments and returns the
+// connection to the host, the largest payload it carries and a function
+// that releases it.
+type listenFunc func(args []string) (conn protocol.Conn, maxPayload uint32, release func(), err error)
+
+// transports maps each transport name to how it is set up.
+var transports = map[string]listenFunc{
+	"stdio": listenStdio,
+	"tcp":   listenTCP,
+	"unix":  listenUnix,
+	"mmap":  listenMmap,
+}
+
+// listenStdio talks to the host over standard input and output.
+func listenStdio(args []string) (protocol.Conn, uint32, func(), error) {
+	// Starting the process made the host's pipes blocking. Make them
+	// pollable again so that stopping on a signal can interrupt a read.
+	conn := protocol.NewStreamConn(pollable(os.Stdin), pollable(os.Stdout))
+	return conn, protocol.MaxStreamPayload, func() {}, nil
+}
+
+// listenTCP listens on the localhost port args[0] and accepts the host's
+// connection. Anyone on the machine can connect to the port, so the host
+// must first send the token it handed the plugin on the descriptor named in
+// EnvTokenFD.
+func listenTCP(args []string) (protocol.Conn, uint32, func(), error) {
+	if len(args) < 1 {
+		return nil, 0, nil, errors.New("tcp transport needs a port argument")
+	}
+	token, err := hostToken()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return listenSocket("tcp", "localhost:"+args[0], func() {}, func(conn net.Conn) error {
+		return peer.VerifyToken(conn, token, authTimeout)
+	})
+}
+
+// listenUnix listens on the Unix domain socket at path args[0] and accepts
+// the host's connection. The socket file is removed once it has. Only the
+// process that started the plugin, run by the same user, may connect. On
+// systems that cannot tell who connected, the permissions of the socket's
+// directory are all that keep others out.
+func listenUnix(args []string) (protocol.Conn, uint32, func(), error) {
+	if len(args) < 1 {
+		return nil, 0, nil, errors.New("unix transport needs a socket path argument")
+	}
+	socketPath := args[0]
+	return listenSocket("unix", socketPath, func() { os.Remove(socketPath) }, func(conn net.Conn) error {
+		if err := peer.Check(conn, os.Getppid()); err != nil && !errors.Is(err, peer.ErrUnsupported) {
+			return err
+		}
+		return nil
+	})
+}
+
+// authTimeout bounds how long a connection may take to authenticate.
+const authTimeout = 5 * time.Second
+
+// hostToken reads the token the host handed the plugin on the descriptor
+// named in EnvTokenFD.
+func hostToken() ([]byte, error) {
+	fd, err := strconv.Atoi(os.Getenv(EnvTokenFD))
+	if err != nil {
+		return nil, fmt.Errorf("tcp transport needs the host's token on a descriptor named in %s", EnvTokenFD)
+	}
+	f := os.NewFile(uintptr(fd), "token")
+	defer f.Close()
+	return peer.ReadToken(f)
+}
+
+// listenSocket listens on addr, prints "ready" so the host knows to
+// connect, and accepts the first connection that passes authenticate. It
+// then stops listening, so no one else can connect, and removes what it
+// listened on.
+func listenSocket(network, addr string, remove func(), authenticate func(net.Conn) error) (protocol.Conn, uint32, func(), error) {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	// Print ready signal to stdout so parent knows we're listening
+	fmt.Println("ready")
+
+	netConn, err := accept(listener, authenticate)
+	listener.Close()
+	remove()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("accept connection: %w", err)
+	}
+	release := func() { netConn.Close() }
+	return protocol.NewStreamConn(netConn, netConn), protocol.MaxStreamPayload, release, nil
+}
+
+// accept accepts connections on listener until one passes authenticate and
+// returns it. Connections are authenticated concurrently, so one that
+// stalls does not hold up the host's. Those that fail are closed and
+// reported on standard error.
+func accept(listener net.Listener, authenticate func(net.Conn) error) (net.Conn, error) {
+	authenticated := make(chan net.Conn, 1)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				failed <- err
+				return
+			}
+			go func() {
+				if err := authenticate(conn); err != nil {
+					fmt.Fprintf(os.Stderr, "Rejected connection from %v: %v\n", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				select {
+				case authenticated <- conn:
+				default:
+					conn.Close()
+				}
+			}()
+		}
+	}()
+	select {
+	case conn := <-authenticated:
+		return conn, nil
+	case err := <-failed:
+		return nil, err
+	}
+}
+
+// listenMmap maps the shared memory file at path args[0], which the host
+// created.
+func listenMmap(args []string) (protocol.Conn, uint32, func(), error) {
+	if len(args) < 1 {
+		return nil, 0, nil, errors.New("mmap transport needs a shared memory file argument")
+	}
+	file, err := os.OpenFile(args[0], os.O_RDWR, 0600)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("open shared memory file: %w", err)
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, protocol.MmapSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, fmt.Errorf("mmap file: %w", err)
+	}
+	release := func() {
+		syscall.Munmap(data)
+		file.Close()
+	}
+
+	// Wait for commands from the parent with a small sleep between checks
+	conn := protocol.NewMmapConn(data, protocol.SidePlugin, protocol.WaitSleep)
+	return conn, protocol.MaxMmapPayload, release, nil
+}
+
+// pollable returns f in non-blocking mode so that it supports deadlines when
+// it is a pipe.
+func pollable(f *os.File) *os.File {
+	fd := f.Fd()
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		return f
+	}
+	return os.NewFile(fd, f.Name())
+}
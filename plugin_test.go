@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/goipcbench/affinity"
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/procstat"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// startTimeout bounds how long a plugin may take to start up.
+const startTimeout = 5 * time.Second
+
+// startFunc is the signature of the host package's Start functions.
+type startFunc func(ctx context.Context, path string, opts host.LaunchOptions) (*host.Plugin, error)
+
+// plugin is a running plugin process as seen from the host.
+type plugin struct {
+	*host.Plugin
+	hello *protocol.Hello
+
+	// nextID numbers requests sent with tryCall.
+	nextID uint32
+}
+
+// launchOptions returns opts set up to serve hostHandler and profile the
+// plugin if asked to.
+func launchOptions(opts host.LaunchOptions) host.LaunchOptions {
+	opts.Client.Handler = hostHandler
+	opts.Hello = protocol.NewHello("goipcbench", 0, "kv.get")
+	opts.ProfileDir = profileDir
+	return opts
+}
+
+// startPlugin starts the plugin built from directory pkg with start.
+// The plugin is closed when tb finishes.
+func startPlugin(tb testing.TB, pkg string, start startFunc, opts host.LaunchOptions) *plugin {
+	tb.Helper()
+	pluginPath := pluginBinary(tb, pkg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	hp, err := start(ctx, pluginPath, launchOptions(opts))
+	if err != nil {
+		tb.Fatalf("Failed to start plugin: %v", err)
+	}
+	tb.Cleanup(func() { hp.Close() })
+	return &plugin{Plugin: hp, hello: hp.Client.Hello()}
+}
+
+// conn returns the connection to the plugin.
+func (p *plugin) conn() protocol.Conn {
+	return p.Client.Conn()
+}
+
+// call sends a request to the plugin and returns the response payload. Any
+// callbacks the plugin makes are answered by hostHandler.
+func (p *plugin) call(tb testing.TB, method string, payload []byte) []byte {
+	tb.Helper()
+	response, err := p.Call(context.Background(), method, payload)
+	if err != nil {
+		tb.Fatalf("Failed to call %s: %v", method, err)
+	}
+	return response
+}
+
+// tryCall sends req to the plugin as is, bypassing the client.
+func (p *plugin) tryCall(req *protocol.Message) (*protocol.Message, error) {
+	p.nextID++
+	req.ID = p.nextID
+	return protocol.Call(p.conn(), req, hostHandler)
+}
+
+// shutdown shuts the plugin down and checks it exits cleanly, leaving none
+// of paths behind.
+func (p *plugin) shutdown(tb testing.TB, paths ...string) {
+	tb.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		tb.Errorf("Failed to shut down plugin: %v", err)
+	}
+	p.waitExit(tb, paths...)
+}
+
+// waitExit waits for the plugin to exit and checks it exited with status 0
+// and removed paths.
+func (p *plugin) waitExit(tb testing.TB, paths ...string) {
+	tb.Helper()
+	select {
+	case <-p.Exited():
+		if err := p.Err().Err; err != nil {
+			tb.Errorf("Plugin process exited with error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		p.Kill()
+		tb.Errorf("Plugin process did not exit")
+		return
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			tb.Errorf("Plugin left %s behind: %v", path, err)
+		}
+	}
+}
+
+// hostConfig is the data plugins look up with the kv.get callback.
+var hostConfig = map[string]string{
+	"color": "blue",
+}
+
+// hostHandler serves the callbacks plugins make into the host.
+func hostHandler(method string, payload []byte) ([]byte, error) {
+	switch method {
+	case "kv.get":
+		return []byte(hostConfig[string(payload)]), nil
+	default:
+		return nil, protocol.Errorf(protocol.CodeUnknownMethod, "unknown callback: %s", method)
+	}
+}
+
+// cost measures the CPU use of the host and a plugin over a benchmark.
+type cost struct {
+	pid            int
+	host, plugin   procstat.Usage
+	pluginMeasured bool
+}
+
+// measureCost starts measuring the CPU use of the host and p.
+func measureCost(b *testing.B, p *plugin) *cost {
+	c := &cost{pid: p.Cmd.Process.Pid}
+	var err error
+	if c.host, err = procstat.Self(); err != nil {
+		b.Fatalf("Failed to read host usage: %v", err)
+	}
+	c.plugin, err = procstat.Process(c.pid)
+	c.pluginMeasured = err == nil
+	return c
+}
+
+// report reports what the host and plugin used per operation since
+// measureCost. The plugin's CPU time has a resolution of 10ms.
+func (c *cost) report(b *testing.B) {
+	host, err := procstat.Self()
+	if err != nil {
+		b.Fatalf("Failed to read host usage: %v", err)
+	}
+	used := host.Sub(c.host)
+	n := float64(b.N)
+	b.ReportMetric(float64(used.CPU)/n, "host-cpu-ns/op")
+	if c.pluginMeasured {
+		plugin, err := procstat.Process(c.pid)
+		if err != nil {
+			b.Fatalf("Failed to read plugin usage: %v", err)
+		}
+		pluginUsed := plugin.Sub(c.plugin)
+		b.ReportMetric(float64(pluginUsed.CPU)/n, "plugin-cpu-ns/op")
+		used = used.Add(pluginUsed)
+	}
+	b.ReportMetric(float64(used.CPU)/n, "cpu-ns/op")
+	b.ReportMetric(float64(used.CtxSwitches)/n, "ctx-switches/op")
+	b.ReportMetric(float64(used.Syscalls)/n, "rw-syscalls/op")
+}
+
+// warmUp calls call for -bench.warmup before a benchmark starts timing.
+func warmUp(call func()) {
+	for end := time.Now().Add(*benchWarmup); time.Now().Before(end); {
+		call()
+	}
+}
+
+func benchmarkPingPong(b *testing.B, p *plugin) {
+	warmUp(func() { p.call(b, "ping", nil) })
+	cost := measureCost(b, p)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if response := p.call(b, "ping", nil); string(response) != "pong" {
+			b.Fatalf("Unexpected response: %s", response)
+		}
+	}
+	b.StopTimer()
+	cost.report(b)
+}
+
+// benchmarkCallback measures a request during which the plugin makes one
+// nested call back into the host.
+func benchmarkCallback(b *testing.B, p *plugin) {
+	key := []byte("color")
+	warmUp(func() { p.call(b, "lookup", key) })
+	cost := measureCost(b, p)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if response := p.call(b, "lookup", key); string(response) != "blue" {
+			b.Fatalf("Unexpected response: %s", response)
+		}
+	}
+	b.StopTimer()
+	cost.report(b)
+}
+
+// benchmarkStream measures receiving one chunk of a streamed reply. Each
+// stream is up to streamChunks long.
+func benchmarkStream(b *testing.B, p *plugin) {
+	const streamChunks = 1000
+	stream := func(n int) {
+		s, err := p.Stream(context.Background(), "count", strconv.AppendInt(nil, int64(n), 10))
+		if err != nil {
+			b.Fatalf("Failed to start stream: %v", err)
+		}
+		for {
+			if _, err := s.Recv(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatalf("Failed to receive chunk: %v", err)
+			}
+		}
+	}
+	warmUp(func() { stream(streamChunks) })
+	cost := measureCost(b, p)
+	b.ResetTimer()
+	for n := b.N; n > 0; n -= streamChunks {
+		stream(min(n, streamChunks))
+	}
+	b.StopTimer()
+	cost.report(b)
+}
+
+func testPingPong(t *testing.T, p *plugin) {
+	for i := 0; i < 5; i++ {
+		if response := p.call(t, "ping", nil); string(response) != "pong" {
+			t.Fatalf("Unexpected response: %s", response)
+		}
+	}
+}
+
+// testHandshake checks the plugin introduced itself as name.
+func testHandshake(t *testing.T, p *plugin, name string, maxPayload uint32) {
+	if p.hello.Name != name {
+		t.Errorf("Plugin name: got %q, want %q", p.hello.Name, name)
+	}
+	if p.hello.Version != protocol.Version {
+		t.Errorf("Plugin protocol version: got %d, want %d", p.hello.Version, protocol.Version)
+	}
+	if p.hello.MaxPayload != maxPayload {
+		t.Errorf("Plugin max payload: got %d, want %d", p.hello.MaxPayload, maxPayload)
+	}
+	for _, method := range []string{"ping", "lookup"} {
+		if !p.hello.Supports(method) {
+			t.Errorf("Plugin does not support %s: %v", method, p.hello.Methods)
+		}
+	}
+}
+
+func testCallback(t *testing.T, p *plugin) {
+	for i := 0; i < 5; i++ {
+		if response := p.call(t, "lookup", []byte("color")); string(response) != "blue" {
+			t.Fatalf("Unexpected response: %s", response)
+		}
+		// Interleaving plain requests must not confuse the nesting
+		if response := p.call(t, "ping", nil); string(response) != "pong" {
+			t.Fatalf("Unexpected response: %s", response)
+		}
+	}
+	if response := p.call(t, "lookup", []byte("missing")); len(response) != 0 {
+		t.Fatalf("Unexpected response for missing key: %s", response)
+	}
+}
+
+// testStream streams replies from the plugin, including one it ends with an
+// error and one the host gives up on.
+func testStream(t *testing.T, p *plugin) {
+	ctx := context.Background()
+	recvAll := func(s *host.Stream) ([]string, error) {
+		var chunks []string
+		for {
+			chunk, err := s.Recv()
+			if err == io.EOF {
+				return chunks, nil
+			} else if err != nil {
+				return chunks, err
+			}
+			chunks = append(chunks, string(chunk))
+		}
+	}
+
+	s, err := p.Stream(ctx, "lines", []byte("starting\nready\nstopping"))
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	if chunks, err := recvAll(s); err != nil || strings.Join(chunks, ",") != "starting,ready,stopping" {
+		t.Fatalf("Unexpected lines: %q, %v", chunks, err)
+	}
+
+	// More chunks than the window, so the host has to grant more
+	s, err = p.Stream(ctx, "count", []byte("100"))
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	if chunks, err := recvAll(s); err != nil || len(chunks) != 100 || chunks[99] != "99" {
+		t.Fatalf("Unexpected count: %d chunks, %v", len(chunks), err)
+	}
+
+	s, err = p.Stream(ctx, "count", []byte("many"))
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	_, err = recvAll(s)
+	expectError(t, err, protocol.CodeInternal)
+
+	// Giving up on a stream leaves the plugin usable
+	s, err = p.Stream(ctx, "count", []byte("1000000"))
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	if _, err := s.Recv(); err != nil {
+		t.Fatalf("Failed to receive chunk: %v", err)
+	}
+	s.Close()
+	testPingPong(t, p)
+}
+
+// expectError asserts that err is an error reply from the plugin with code.
+func expectError(t *testing.T, err error, code protocol.Code) {
+	t.Helper()
+	var perr *protocol.Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected error reply with code %v, got %v", code, err)
+	}
+	if perr.Code != code {
+		t.Fatalf("Expected error reply with code %v, got %v", code, perr)
+	}
+}
+
+// testErrors sends unknown and malformed commands and checks that each gets
+// an error reply and leaves the plugin usable.
+func testErrors(t *testing.T, p *plugin) {
+	_, err := p.Call(context.Background(), "bogus", nil)
+	expectError(t, err, protocol.CodeUnknownMethod)
+
+	_, err = p.tryCall(&protocol.Message{Type: 42, Method: "ping"})
+	expectError(t, err, protocol.CodeMalformed)
+
+	_, err = p.tryCall(&protocol.Message{Type: protocol.TypeResponse, Method: "ping"})
+	expectError(t, err, protocol.CodeMalformed)
+
+	// A frame whose method length runs past the end of the frame
+	frames := p.conn().(interface{ WriteFrame([]byte) error })
+	if err := frames.WriteFrame([]byte{0, 0, 0, 6, byte(protocol.TypeRequest), 0, 0, 0, 1, 200}); err != nil {
+		t.Fatalf("Failed to write malformed frame: %v", err)
+	}
+	reply, err := p.conn().ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read reply to malformed frame: %v", err)
+	}
+	expectError(t, reply.Err(), protocol.CodeMalformed)
+
+	testPingPong(t, p)
+}
+
+// testShutdown shuts the plugin down after it has served requests and
+// checks it removed paths before exiting.
+func testShutdown(t *testing.T, p *plugin, paths ...string) {
+	testPingPong(t, p)
+	p.shutdown(t, paths...)
+
+	// The host can still release the plugin afterwards
+	if err := p.Close(); err != nil {
+		t.Errorf("Failed to close plugin: %v", err)
+	}
+}
+
+// testTerminate sends the plugin SIGTERM and checks it exits cleanly, leaving
+// none of paths behind. If busy, the signal arrives during a slow request,
+// which must still be answered.
+func testTerminate(t *testing.T, p *plugin, busy bool, paths ...string) {
+	testPingPong(t, p)
+	terminate := func() {
+		if err := p.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			t.Errorf("Failed to signal plugin: %v", err)
+		}
+	}
+
+	if busy {
+		time.AfterFunc(50*time.Millisecond, terminate)
+		if response, err := p.Call(context.Background(), "sleep", []byte("200ms")); err != nil || string(response) != "200ms" {
+			t.Fatalf("Request in progress at SIGTERM: got %q, %v", response, err)
+		}
+	} else {
+		terminate()
+	}
+	p.waitExit(t, paths...)
+}
+
+// testTimeout gives up on a slow request and checks the call returns at the
+// deadline and the plugin is usable afterwards.
+func testTimeout(t *testing.T, p *plugin) {
+	for _, sendCancel := range []bool{false, true} {
+		p.Client = host.NewClient(p.conn(), host.ClientOptions{Handler: hostHandler, SendCancel: sendCancel})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err := p.Call(ctx, "sleep", []byte("300ms"))
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Fatalf("Call returned %v after its deadline", elapsed)
+		}
+
+		// An already canceled context fails without touching the plugin
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		if _, err := p.Call(ctx, "ping", nil); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected canceled, got %v", err)
+		}
+
+		// The late reply to the sleep is discarded
+		testPingPong(t, p)
+		testCallback(t, p)
+	}
+}
+
+// testCrash kills the plugin with SIGKILL in the middle of a call and checks
+// the call fails with a host.ExitError, then that a supervisor set to restart
+// recovers.
+func testCrash(t *testing.T, pkg string, start startFunc, opts host.LaunchOptions) {
+	pluginPath := pluginBinary(t, pkg)
+	opts = launchOptions(opts)
+	startPlugin := func(ctx context.Context) (*host.Plugin, error) {
+		return start(ctx, pluginPath, opts)
+	}
+
+	for _, restart := range []bool{false, true} {
+		s := host.NewSupervisor(startPlugin, host.SupervisorOptions{Restart: restart})
+		ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+		if err := s.Start(ctx); err != nil {
+			cancel()
+			t.Fatalf("Failed to start plugin: %v", err)
+		}
+
+		killed := s.Plugin()
+		killedAt := make(chan time.Time, 1)
+		time.AfterFunc(50*time.Millisecond, func() {
+			killedAt <- time.Now()
+			killed.Kill()
+		})
+		_, err := s.Call(ctx, "sleep", []byte("10s"))
+		var exitErr *host.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("Expected plugin exited error, got %v", err)
+		}
+		begin := <-killedAt
+		if elapsed := time.Since(begin); elapsed > time.Second {
+			t.Fatalf("Took %v to notice the plugin died", elapsed)
+		}
+
+		if restart {
+			// The next call waits for the restart
+			if response, err := s.Call(ctx, "ping", nil); err != nil || string(response) != "pong" {
+				t.Fatalf("Call after restart: got %q, %v", response, err)
+			}
+			t.Logf("Recovered %v after kill", time.Since(begin))
+			if s.Restarts() != 1 {
+				t.Errorf("Expected 1 restart, got %d", s.Restarts())
+			}
+		} else {
+			if _, err := s.Call(ctx, "ping", nil); !errors.As(err, &exitErr) {
+				t.Fatalf("Expected plugin exited error for call after death, got %v", err)
+			}
+		}
+
+		cancel()
+		if err := s.Close(); err != nil {
+			t.Errorf("Failed to close supervisor: %v", err)
+		}
+	}
+}
+
+// TestSupervisorCloseDuringRestart closes a supervisor while it restarts the
+// plugin and checks the replacement is closed with it rather than left
+// running.
+func TestSupervisorCloseDuringRestart(t *testing.T) {
+	pluginPath := pluginBinary(t, "unix")
+	opts := launchOptions(host.LaunchOptions{})
+	restarting := make(chan struct{})
+	replacement := make(chan *host.Plugin, 1)
+	starts := 0
+	s := host.NewSupervisor(func(ctx context.Context) (*host.Plugin, error) {
+		starts++
+		if starts == 1 {
+			return host.StartUnix(ctx, pluginPath, opts)
+		}
+		// The restart finishes once Close has begun
+		close(restarting)
+		<-ctx.Done()
+		p, err := host.StartUnix(context.Background(), pluginPath, opts)
+		if err == nil {
+			replacement <- p
+		}
+		return p, err
+	}, host.SupervisorOptions{Restart: true})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+
+	s.Plugin().Kill()
+	<-restarting
+	s.Close()
+	p := <-replacement
+	select {
+	case <-p.Exited():
+	default:
+		p.Close()
+		t.Errorf("Replacement plugin is still running after Close")
+	}
+	if _, err := s.Call(context.Background(), "ping", nil); err != host.ErrClosed {
+		t.Errorf("Call after Close: got %v, want ErrClosed", err)
+	}
+}
+
+// TestSupervisorRestartsClosePrevious kills the plugin twice without calling
+// it and checks each restart releases the instance it replaces.
+func TestSupervisorRestartsClosePrevious(t *testing.T) {
+	pluginPath := pluginBinary(t, "unix")
+	opts := launchOptions(host.LaunchOptions{})
+	s := host.NewSupervisor(func(ctx context.Context) (*host.Plugin, error) {
+		return host.StartUnix(ctx, pluginPath, opts)
+	}, host.SupervisorOptions{Restart: true, MinBackoff: time.Microsecond})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	defer s.Close()
+
+	var replaced []*host.Plugin
+	for range 2 {
+		p := s.Plugin()
+		p.Kill()
+		deadline := time.Now().Add(startTimeout)
+		for s.Plugin() == p {
+			if time.Now().After(deadline) {
+				t.Fatalf("Plugin was not restarted within %v", startTimeout)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		replaced = append(replaced, p)
+	}
+	for i, p := range replaced {
+		// The socket's temporary directory is removed when it is closed
+		dir := filepath.Dir(p.Cmd.Args[len(p.Cmd.Args)-1])
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Instance %d was not closed when replaced: stat %s: %v", i, dir, err)
+		}
+	}
+}
+
+// TestSupervisorCloseWakesCall closes a supervisor while a call waits for a
+// restart that never succeeds and checks neither blocks.
+func TestSupervisorCloseWakesCall(t *testing.T) {
+	pluginPath := pluginBinary(t, "unix")
+	opts := launchOptions(host.LaunchOptions{})
+	started := false
+	s := host.NewSupervisor(func(ctx context.Context) (*host.Plugin, error) {
+		if started {
+			return nil, errors.New("no more plugins")
+		}
+		started = true
+		return host.StartUnix(ctx, pluginPath, opts)
+	}, host.SupervisorOptions{Restart: true})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+
+	p := s.Plugin()
+	p.Kill()
+	<-p.Exited()
+	called := make(chan error, 1)
+	go func() {
+		_, err := s.Call(context.Background(), "ping", nil)
+		called <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-called:
+		if err != host.ErrClosed {
+			t.Errorf("Waiting call: got %v, want ErrClosed", err)
+		}
+	case <-time.After(startTimeout):
+		t.Fatal("Waiting call did not return after Close")
+	}
+	select {
+	case <-closed:
+	case <-time.After(startTimeout):
+		t.Fatal("Close did not return")
+	}
+}
+
+// benchmarkRecovery measures the time from killing the plugin to the first
+// successful call to its replacement.
+func benchmarkRecovery(b *testing.B, pkg string, start startFunc, opts host.LaunchOptions) {
+	pluginPath := pluginBinary(b, pkg)
+	opts = launchOptions(opts)
+	s := host.NewSupervisor(func(ctx context.Context) (*host.Plugin, error) {
+		return start(ctx, pluginPath, opts)
+	}, host.SupervisorOptions{Restart: true, MinBackoff: time.Microsecond})
+	if err := s.Start(context.Background()); err != nil {
+		b.Fatalf("Failed to start plugin: %v", err)
+	}
+	defer s.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Plugin().Kill()
+		ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+		for {
+			_, err := s.Call(ctx, "ping", nil)
+			if err == nil {
+				break
+			}
+			var exitErr *host.ExitError
+			if !errors.As(err, &exitErr) {
+				b.Fatalf("Failed to call ping: %v", err)
+			}
+			if ctx.Err() != nil {
+				b.Fatalf("Plugin did not recover within %v: %v", startTimeout, err)
+			}
+		}
+		cancel()
+	}
+	b.StopTimer()
+}
+
+// benchmarkPlacement runs benchmarkPingPong with the host and plugin pinned
+// to CPUs at each placement, skipping placements the machine cannot make.
+func benchmarkPlacement(b *testing.B, pkg string, start startFunc, opts host.LaunchOptions) {
+	cpus, err := affinity.Topology()
+	if err != nil {
+		b.Skipf("CPU topology unavailable: %v", err)
+	}
+	for _, name := range affinity.Placements {
+		b.Run(name, func(b *testing.B) {
+			placement, ok := affinity.Place(cpus, name)
+			if !ok {
+				b.Skipf("No %s pair of CPUs among %v", name, cpus)
+			}
+			opts.CPUs = []int{placement.Plugin}
+			p := startPlugin(b, pkg, start, opts)
+
+			unlock, err := affinity.LockThread([]int{placement.Host})
+			if err != nil {
+				b.Fatalf("Failed to pin host thread: %v", err)
+			}
+			defer unlock()
+			benchmarkPingPong(b, p)
+			p.shutdown(b)
+		})
+	}
+}
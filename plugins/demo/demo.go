@@ -0,0 +1,131 @@
+// Package demo holds the methods the benchmark plugins serve, for any
+// transport.
+package demo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jackc/goipcbench/plugin"
+)
+
+// Register registers the demo methods on s:
+//
+//   - ping replies "pong".
+//   - lookup asks the host for the value of the key in the payload with
+//     kv.get and replies with it.
+//   - echo replies with the payload.
+//   - sleep sleeps for the duration in the payload, such as "10ms", to
+//     stand in for slow work and replies with the payload.
+//   - spin uses the CPU for the duration in the payload.
+//   - alloc allocates and touches the number of bytes in the payload, to
+//     test memory limits, and replies with the payload.
+//   - open opens /dev/null as many times as the number in the payload at
+//     once, to test limits on open files, and replies with the payload.
+//   - read reads a byte from the descriptor numbered in the payload with
+//     read(2), to test sandboxes, and replies with what it read.
+//   - lines streams each line of the payload, as a log processor might
+//     stream its results.
+//   - count streams the numbers from 0 up to the number in the payload.
+func Register(s *plugin.Server) {
+	s.Handle("ping", func(*plugin.Request) ([]byte, error) {
+		return []byte("pong"), nil
+	})
+	s.Handle("lookup", func(req *plugin.Request) ([]byte, error) {
+		return req.Call("kv.get", req.Payload)
+	})
+	s.Handle("echo", func(req *plugin.Request) ([]byte, error) {
+		return req.Payload, nil
+	})
+	s.Handle("sleep", func(req *plugin.Request) ([]byte, error) {
+		d, err := time.ParseDuration(string(req.Payload))
+		if err != nil {
+			return nil, err
+		}
+		time.Sleep(d)
+		return req.Payload, nil
+	})
+	s.Handle("spin", func(req *plugin.Request) ([]byte, error) {
+		d, err := time.ParseDuration(string(req.Payload))
+		if err != nil {
+			return nil, err
+		}
+		for start := time.Now(); time.Since(start) < d; {
+		}
+		return req.Payload, nil
+	})
+	s.Handle("alloc", func(req *plugin.Request) ([]byte, error) {
+		n, err := strconv.Atoi(string(req.Payload))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		for i := 0; i < n; i += os.Getpagesize() {
+			buf[i] = 1
+		}
+		return req.Payload, nil
+	})
+	s.Handle("open", func(req *plugin.Request) ([]byte, error) {
+		n, err := strconv.Atoi(string(req.Payload))
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			f, err := os.Open(os.DevNull)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+		}
+		return req.Payload, nil
+	})
+	s.Handle("read", func(req *plugin.Request) ([]byte, error) {
+		fd, err := strconv.Atoi(string(req.Payload))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 1)
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	})
+	s.HandleStream("lines", func(req *plugin.Request) error {
+		for line := range bytes.Lines(req.Payload) {
+			if err := req.Send(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.HandleStream("count", func(req *plugin.Request) error {
+		n, err := strconv.Atoi(string(req.Payload))
+		if err != nil {
+			return err
+		}
+		var buf []byte
+		for i := range n {
+			buf = strconv.AppendInt(buf[:0], int64(i), 10)
+			if err := req.Send(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Main serves the demo methods as name over transport with the transport
+// arguments args, and exits with status 1 if that fails.
+func Main(name, transport string, args []string) {
+	s := plugin.New(name)
+	Register(s)
+	if err := s.ServeTransport(transport, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
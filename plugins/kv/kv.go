@@ -0,0 +1,75 @@
+// Package kv is a key-value store served by a plugin, as an example of a
+// typed plugin API generated by goipcgen.
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//go:generate go run ../../cmd/goipcgen -type KV
+
+// KV is a key-value store.
+type KV interface {
+	// Get returns the entry for key, which is not Found if there is none.
+	Get(ctx context.Context, key string) (Entry, error)
+	// Set stores entry and returns whether it replaced one.
+	Set(ctx context.Context, entry Entry) (bool, error)
+	// Keys returns the keys in the store modified since a time.
+	Keys(ctx context.Context, since time.Time) ([]string, error)
+}
+
+// Entry is a value stored under a key.
+type Entry struct {
+	Key      string    `json:"key"`
+	Value    []byte    `json:"value"`
+	Found    bool      `json:"found"`
+	Modified time.Time `json:"modified"`
+}
+
+// Store is an in-memory KV.
+type Store struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{entries: map[string]Entry{}}
+}
+
+// Get returns the entry for key.
+func (s *Store) Get(ctx context.Context, key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return Entry{Key: key}, nil
+	}
+	return entry, nil
+}
+
+// Set stores entry, marking it found and modified now.
+func (s *Store) Set(ctx context.Context, entry Entry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, replaced := s.entries[entry.Key]
+	entry.Found = true
+	entry.Modified = time.Now()
+	s.entries[entry.Key] = entry
+	return replaced, nil
+}
+
+// Keys returns the keys modified after since.
+func (s *Store) Keys(ctx context.Context, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key, entry := range s.entries {
+		if entry.Modified.After(since) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,11 @@
+// Package mmap is the plugin that talks to the host through a shared memory
+// region.
+package mmap
+
+import "github.com/jackc/goipcbench/plugins/demo"
+
+// Main runs the plugin with the transport arguments args and returns once it
+// has shut down. Its argument is the shared memory file the host created.
+func Main(args []string) {
+	demo.Main("mmap", "mmap", args)
+}
@@ -0,0 +1,128 @@
+package orders
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// The binary encoding of an Order is its fields in order, integers as
+// varints and strings and slices prefixed with their length.
+
+// AppendBinary implements encoding.BinaryAppender.
+func (o *Order) AppendBinary(buf []byte) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, o.ID)
+	buf = appendString(buf, o.Customer)
+	buf = binary.AppendVarint(buf, o.Created.UnixNano())
+	buf = binary.AppendUvarint(buf, uint64(len(o.Items)))
+	for _, item := range o.Items {
+		buf = appendString(buf, item.SKU)
+		buf = binary.AppendUvarint(buf, uint64(item.Quantity))
+		buf = binary.AppendVarint(buf, item.Price)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(o.Tags)))
+	for _, tag := range o.Tags {
+		buf = appendString(buf, tag)
+	}
+	return buf, nil
+}
+
+// DecodeBinary implements codec.BinaryDecoder.
+func (o *Order) DecodeBinary(data []byte) error {
+	d := decoder{data: data}
+	o.ID = d.uvarint()
+	o.Customer = d.string()
+	o.Created = time.Unix(0, d.varint())
+	o.Items = make([]Item, d.count())
+	for i := range o.Items {
+		o.Items[i] = Item{SKU: d.string(), Quantity: uint32(d.uvarint()), Price: d.varint()}
+	}
+	o.Tags = make([]string, d.count())
+	for i := range o.Tags {
+		o.Tags[i] = d.string()
+	}
+	return d.finish()
+}
+
+// AppendBinary implements encoding.BinaryAppender.
+func (r *Receipt) AppendBinary(buf []byte) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, r.OrderID)
+	buf = binary.AppendUvarint(buf, uint64(r.Quantity))
+	buf = binary.AppendVarint(buf, r.Total)
+	return buf, nil
+}
+
+// DecodeBinary implements codec.BinaryDecoder.
+func (r *Receipt) DecodeBinary(data []byte) error {
+	d := decoder{data: data}
+	r.OrderID = d.uvarint()
+	r.Quantity = uint32(d.uvarint())
+	r.Total = d.varint()
+	return d.finish()
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+var errShort = errors.New("unexpected end of data")
+
+// decoder reads a binary encoding. After the first error it reads zeros and
+// finish reports the error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(errShort)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail(errShort)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads the length of a slice, each element of which takes at least a
+// byte, so a corrupt length cannot make the caller allocate without bound.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail(errShort)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.data = nil
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		return errors.New("trailing data")
+	}
+	return d.err
+}
@@ -0,0 +1,236 @@
+package orders
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The flat encoding of an Order puts every field at an offset that can be
+// computed without reading what comes before, so an OrderView reads fields
+// straight from the encoded bytes. Integers are little endian, as the
+// machines this runs on are. It starts with a header:
+//
+//	0  ID            uint64
+//	8  Created       int64 Unix nanoseconds
+//	16 Customer      string reference
+//	24 len(Items)    uint32
+//	28 len(Tags)     uint32
+//
+// followed by the items, the tags and the bytes of the strings. An item is
+//
+//	0  SKU           string reference
+//	8  Quantity      uint32
+//	12               unused
+//	16 Price         int64
+//
+// and a tag is a string reference. A string reference is the uint32 offset
+// of the string's bytes from the start of the order and its uint32 length.
+const (
+	orderHeaderSize = 32
+	flatItemSize    = 24
+	flatStringSize  = 8
+)
+
+var le = binary.LittleEndian
+
+// AppendFlat implements codec.FlatAppender.
+func (o *Order) AppendFlat(buf []byte) ([]byte, error) {
+	start := len(buf)
+	tags := orderHeaderSize + len(o.Items)*flatItemSize
+	strings := tags + len(o.Tags)*flatStringSize
+	buf = append(buf, make([]byte, strings)...)
+	order := buf[start:]
+
+	le.PutUint64(order[0:], o.ID)
+	le.PutUint64(order[8:], uint64(o.Created.UnixNano()))
+	le.PutUint32(order[24:], uint32(len(o.Items)))
+	le.PutUint32(order[28:], uint32(len(o.Tags)))
+
+	// Append each string and point its reference at it. The references are
+	// written through buf as appending may move it.
+	appendRef := func(ref int, s string) {
+		le.PutUint32(buf[start+ref:], uint32(len(buf)-start))
+		le.PutUint32(buf[start+ref+4:], uint32(len(s)))
+		buf = append(buf, s...)
+	}
+	appendRef(16, o.Customer)
+	for i, item := range o.Items {
+		at := orderHeaderSize + i*flatItemSize
+		appendRef(at, item.SKU)
+		le.PutUint32(buf[start+at+8:], item.Quantity)
+		le.PutUint64(buf[start+at+16:], uint64(item.Price))
+	}
+	for i, tag := range o.Tags {
+		appendRef(tags+i*flatStringSize, tag)
+	}
+	return buf, nil
+}
+
+// UnmarshalFlat implements codec.FlatUnmarshaler by copying the order out
+// of an OrderView. It does not retain data.
+func (o *Order) UnmarshalFlat(data []byte) error {
+	var v OrderView
+	if err := v.UnmarshalFlat(data); err != nil {
+		return err
+	}
+	*o = v.Order()
+	return nil
+}
+
+// OrderView reads a flat encoded Order in place. The strings it returns
+// alias the encoding.
+type OrderView struct {
+	data []byte
+}
+
+// UnmarshalFlat implements codec.FlatUnmarshaler. It checks every reference
+// in data lies within it, so the accessors of v cannot fail, and retains
+// data.
+func (v *OrderView) UnmarshalFlat(data []byte) error {
+	if len(data) < orderHeaderSize {
+		return fmt.Errorf("%d byte order is shorter than its header", len(data))
+	}
+	items, tags := uint64(le.Uint32(data[24:])), uint64(le.Uint32(data[28:]))
+	if orderHeaderSize+items*flatItemSize+tags*flatStringSize > uint64(len(data)) {
+		return fmt.Errorf("%d items and %d tags exceed %d byte order", items, tags, len(data))
+	}
+	check := func(ref int) error {
+		if uint64(le.Uint32(data[ref:]))+uint64(le.Uint32(data[ref+4:])) > uint64(len(data)) {
+			return errors.New("string reference exceeds order")
+		}
+		return nil
+	}
+	if err := check(16); err != nil {
+		return err
+	}
+	for i := range int(items) {
+		if err := check(orderHeaderSize + i*flatItemSize); err != nil {
+			return err
+		}
+	}
+	for i := range int(tags) {
+		if err := check(orderHeaderSize + int(items)*flatItemSize + i*flatStringSize); err != nil {
+			return err
+		}
+	}
+	v.data = data
+	return nil
+}
+
+// ID returns the order's ID.
+func (v OrderView) ID() uint64 {
+	return le.Uint64(v.data[0:])
+}
+
+// Created returns when the order was created.
+func (v OrderView) Created() time.Time {
+	return time.Unix(0, int64(le.Uint64(v.data[8:])))
+}
+
+// Customer returns the customer's name.
+func (v OrderView) Customer() []byte {
+	return v.string(16)
+}
+
+// Items returns the number of items.
+func (v OrderView) Items() int {
+	return int(le.Uint32(v.data[24:]))
+}
+
+// Item returns item i.
+func (v OrderView) Item(i int) ItemView {
+	at := orderHeaderSize + i*flatItemSize
+	return ItemView{order: v, at: at}
+}
+
+// Tags returns the number of tags.
+func (v OrderView) Tags() int {
+	return int(le.Uint32(v.data[28:]))
+}
+
+// Tag returns tag i.
+func (v OrderView) Tag(i int) []byte {
+	return v.string(orderHeaderSize + v.Items()*flatItemSize + i*flatStringSize)
+}
+
+// Receipt returns the receipt for the order, reading only the fields it
+// needs.
+func (v OrderView) Receipt() Receipt {
+	r := Receipt{OrderID: v.ID()}
+	for i := range v.Items() {
+		item := v.Item(i)
+		r.Quantity += item.Quantity()
+		r.Total += int64(item.Quantity()) * item.Price()
+	}
+	return r
+}
+
+// Order copies the order out of v.
+func (v OrderView) Order() Order {
+	o := Order{
+		ID:       v.ID(),
+		Customer: string(v.Customer()),
+		Created:  v.Created(),
+		Items:    make([]Item, v.Items()),
+		Tags:     make([]string, v.Tags()),
+	}
+	for i := range o.Items {
+		item := v.Item(i)
+		o.Items[i] = Item{SKU: string(item.SKU()), Quantity: item.Quantity(), Price: item.Price()}
+	}
+	for i := range o.Tags {
+		o.Tags[i] = string(v.Tag(i))
+	}
+	return o
+}
+
+// string returns the string referenced at offset ref.
+func (v OrderView) string(ref int) []byte {
+	off, n := le.Uint32(v.data[ref:]), le.Uint32(v.data[ref+4:])
+	return v.data[off : off+n : off+n]
+}
+
+// ItemView reads an item of a flat encoded Order in place.
+type ItemView struct {
+	order OrderView
+	at    int
+}
+
+// SKU returns the item's SKU.
+func (v ItemView) SKU() []byte {
+	return v.order.string(v.at)
+}
+
+// Quantity returns how many were ordered.
+func (v ItemView) Quantity() uint32 {
+	return le.Uint32(v.order.data[v.at+8:])
+}
+
+// Price returns the price of one in cents.
+func (v ItemView) Price() int64 {
+	return int64(le.Uint64(v.order.data[v.at+16:]))
+}
+
+// The flat encoding of a Receipt is its OrderID and Total as uint64 and
+// Quantity as uint32, little endian.
+const flatReceiptSize = 20
+
+// AppendFlat implements codec.FlatAppender.
+func (r *Receipt) AppendFlat(buf []byte) ([]byte, error) {
+	buf = le.AppendUint64(buf, r.OrderID)
+	buf = le.AppendUint64(buf, uint64(r.Total))
+	return le.AppendUint32(buf, r.Quantity), nil
+}
+
+// UnmarshalFlat implements codec.FlatUnmarshaler. It does not retain data.
+func (r *Receipt) UnmarshalFlat(data []byte) error {
+	if len(data) != flatReceiptSize {
+		return fmt.Errorf("%d byte receipt, want %d", len(data), flatReceiptSize)
+	}
+	r.OrderID = le.Uint64(data[0:])
+	r.Total = int64(le.Uint64(data[8:]))
+	r.Quantity = le.Uint32(data[16:])
+	return nil
+}
@@ -0,0 +1,98 @@
+// Package orders is a plugin that totals orders, as an example of a
+// realistic struct payload to compare codecs with. Each codec in package
+// codec has a method "order.<codec>" taking an Order and returning its
+// Receipt in that codec.
+package orders
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/goipcbench/codec"
+	"github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// Order is an order for some items.
+type Order struct {
+	ID       uint64
+	Customer string
+	Created  time.Time
+	Items    []Item
+	Tags     []string
+}
+
+// Item is a line of an Order.
+type Item struct {
+	SKU      string
+	Quantity uint32
+	// Price is the price of one in cents.
+	Price int64
+}
+
+// Receipt totals an Order.
+type Receipt struct {
+	OrderID  uint64
+	Quantity uint32
+	// Total is in cents.
+	Total int64
+}
+
+// Receipt returns the receipt for o.
+func (o *Order) Receipt() Receipt {
+	r := Receipt{OrderID: o.ID}
+	for _, item := range o.Items {
+		r.Quantity += item.Quantity
+		r.Total += int64(item.Quantity) * item.Price
+	}
+	return r
+}
+
+// NewOrder returns an order with the given number of items, made up to be
+// typical of a web shop.
+func NewOrder(id uint64, items int) *Order {
+	o := &Order{
+		ID:       id,
+		Customer: "Ada Lovelace <ada@example.com>",
+		Created:  time.Date(2024, 3, 14, 15, 9, 26, 535897932, time.UTC),
+		Tags:     []string{"web", "priority", "gift-wrap"},
+	}
+	for i := range items {
+		o.Items = append(o.Items, Item{
+			SKU:      fmt.Sprintf("SKU-%06d", 1000+i*37),
+			Quantity: uint32(i%4 + 1),
+			Price:    int64(199 + i*250),
+		})
+	}
+	return o
+}
+
+// Register registers "order.<codec>" on s for each codec in codec.All.
+func Register(s *plugin.Server) {
+	for _, c := range codec.All {
+		s.Handle("order."+c.Name(), func(req *plugin.Request) ([]byte, error) {
+			r, err := receipt(c, req.Payload)
+			if err != nil {
+				return nil, protocol.Errorf(protocol.CodeMalformed, "decode %s order: %v", c.Name(), err)
+			}
+			return c.Append(nil, &r)
+		})
+	}
+}
+
+// receipt decodes an order from payload with c and returns its receipt. A
+// flat order is read in place through an OrderView.
+func receipt(c codec.Codec, payload []byte) (Receipt, error) {
+	if c == codec.Flat {
+		var v OrderView
+		if err := c.Unmarshal(payload, &v); err != nil {
+			return Receipt{}, err
+		}
+		return v.Receipt(), nil
+	}
+	var o Order
+	if err := c.Unmarshal(payload, &o); err != nil {
+		return Receipt{}, err
+	}
+	return o.Receipt(), nil
+}
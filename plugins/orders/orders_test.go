@@ -0,0 +1,147 @@
+package orders
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/goipcbench/codec"
+)
+
+// itemCounts are the order sizes the benchmarks encode.
+var itemCounts = []int{1, 8, 32}
+
+// equal reports whether a and b are the same order, as the codecs do not
+// keep the location of Created or tell nil slices from empty ones.
+func equal(a, b *Order) bool {
+	x, y := *a, *b
+	x.Created, y.Created = x.Created.UTC(), y.Created.UTC()
+	for _, o := range []*Order{&x, &y} {
+		if len(o.Items) == 0 {
+			o.Items = nil
+		}
+		if len(o.Tags) == 0 {
+			o.Tags = nil
+		}
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range codec.All {
+		for _, items := range []int{0, 1, 8} {
+			want := NewOrder(42, items)
+			data, err := c.Append(nil, want)
+			if err != nil {
+				t.Fatalf("%s: Append: %v", c.Name(), err)
+			}
+			var got Order
+			if err := c.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: Unmarshal: %v", c.Name(), err)
+			}
+			if !equal(&got, want) {
+				t.Errorf("%s: got %+v, want %+v", c.Name(), got, *want)
+			}
+
+			r, err := receipt(c, data)
+			if err != nil || r != want.Receipt() {
+				t.Errorf("%s: receipt: got %+v, %v, want %+v", c.Name(), r, err, want.Receipt())
+			}
+		}
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	o := NewOrder(7, 3)
+	want := Receipt{OrderID: 7, Quantity: 1 + 2 + 3, Total: 1*199 + 2*449 + 3*699}
+	if got := o.Receipt(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for _, c := range codec.All {
+		data, err := c.Append(nil, &want)
+		if err != nil {
+			t.Fatalf("%s: Append: %v", c.Name(), err)
+		}
+		var got Receipt
+		if err := c.Unmarshal(data, &got); err != nil || got != want {
+			t.Errorf("%s: Unmarshal: got %+v, %v", c.Name(), got, err)
+		}
+	}
+}
+
+func TestOrderViewInPlace(t *testing.T) {
+	data, _ := NewOrder(1, 2).AppendFlat(nil)
+	var v OrderView
+	if err := v.UnmarshalFlat(data); err != nil {
+		t.Fatal(err)
+	}
+	sku := v.Item(1).SKU()
+	if string(sku) != "SKU-001037" {
+		t.Fatalf("SKU: got %q", sku)
+	}
+	sku[0] = 's'
+	if string(v.Order().Items[1].SKU) != "sKU-001037" {
+		t.Errorf("view does not read the encoding in place")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	for _, c := range []codec.Codec{codec.Binary, codec.Flat} {
+		data, _ := c.Append(nil, NewOrder(1, 8))
+		for _, n := range []int{0, 1, 20, len(data) / 2, len(data) - 1} {
+			var o Order
+			if err := c.Unmarshal(data[:n], &o); err == nil {
+				t.Errorf("%s: Unmarshal of %d of %d bytes succeeded", c.Name(), n, len(data))
+			}
+		}
+	}
+
+	// A string reference past the end
+	data, _ := NewOrder(1, 1).AppendFlat(nil)
+	le.PutUint32(data[orderHeaderSize:], uint32(len(data)))
+	var v OrderView
+	if err := v.UnmarshalFlat(data); err == nil {
+		t.Errorf("UnmarshalFlat accepted a reference past the end")
+	}
+}
+
+// BenchmarkAppend measures encoding an order without a transport.
+func BenchmarkAppend(b *testing.B) {
+	for _, c := range codec.All {
+		for _, items := range itemCounts {
+			b.Run(fmt.Sprintf("%s/items=%d", c.Name(), items), func(b *testing.B) {
+				o := NewOrder(1, items)
+				var buf []byte
+				b.ReportAllocs()
+				for b.Loop() {
+					var err error
+					if buf, err = c.Append(buf[:0], o); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(buf)), "bytes")
+			})
+		}
+	}
+}
+
+// BenchmarkReceipt measures decoding an order and totalling it, as the
+// plugin does, without a transport.
+func BenchmarkReceipt(b *testing.B) {
+	for _, c := range codec.All {
+		for _, items := range itemCounts {
+			b.Run(fmt.Sprintf("%s/items=%d", c.Name(), items), func(b *testing.B) {
+				data, err := c.Append(nil, NewOrder(1, items))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := receipt(c, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
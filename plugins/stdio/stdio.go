@@ -0,0 +1,11 @@
+// Package stdio is the plugin that talks to the host over its standard
+// input and output.
+package stdio
+
+import "github.com/jackc/goipcbench/plugins/demo"
+
+// Main runs the plugin with the transport arguments args and returns once it
+// has shut down. It takes no arguments.
+func Main(args []string) {
+	demo.Main("stdio", "stdio", args)
+}
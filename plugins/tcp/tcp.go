@@ -0,0 +1,11 @@
+// Package tcp is the plugin that talks to the host over a TCP connection on
+// localhost.
+package tcp
+
+import "github.com/jackc/goipcbench/plugins/demo"
+
+// Main runs the plugin with the transport arguments args and returns once it
+// has shut down. Its argument is the port to listen on.
+func Main(args []string) {
+	demo.Main("tcp", "tcp", args)
+}
@@ -0,0 +1,11 @@
+// Package unix is the plugin that talks to the host over a Unix domain
+// socket.
+package unix
+
+import "github.com/jackc/goipcbench/plugins/demo"
+
+// Main runs the plugin with the transport arguments args and returns once it
+// has shut down. Its argument is the path of the socket to listen on.
+func Main(args []string) {
+	demo.Main("unix", "unix", args)
+}
@@ -0,0 +1,60 @@
+// Package procstat reads how much CPU time, context switching and system
+// calls a process has used, to measure what IPC costs beyond wall time.
+package procstat
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// ErrUnsupported is returned on systems where the usage of another process
+// cannot be read.
+var ErrUnsupported = errors.New("process usage is not supported on " + runtime.GOOS)
+
+// Usage is what a process has used since it started.
+type Usage struct {
+	// CPU is the user and system CPU time.
+	CPU time.Duration
+	// CtxSwitches counts voluntary and involuntary context switches.
+	CtxSwitches int64
+	// Syscalls counts read and write system calls, such as read, write,
+	// recvmsg and sendmsg, from /proc/pid/io. Other system calls, such as
+	// futex, nanosleep, sched_yield and epoll_wait, are not counted. It is
+	// zero where it cannot be read.
+	Syscalls int64
+}
+
+// Sub returns the usage between v and u, taken later.
+func (u Usage) Sub(v Usage) Usage {
+	return Usage{
+		CPU:         u.CPU - v.CPU,
+		CtxSwitches: u.CtxSwitches - v.CtxSwitches,
+		Syscalls:    u.Syscalls - v.Syscalls,
+	}
+}
+
+// Add returns the combined usage of u and v.
+func (u Usage) Add(v Usage) Usage {
+	return Usage{
+		CPU:         u.CPU + v.CPU,
+		CtxSwitches: u.CtxSwitches + v.CtxSwitches,
+		Syscalls:    u.Syscalls + v.Syscalls,
+	}
+}
+
+// Self returns the usage of the calling process, including threads that
+// have exited.
+func Self() (Usage, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return Usage{}, err
+	}
+	u := Usage{
+		CPU:         time.Duration(ru.Utime.Nano() + ru.Stime.Nano()),
+		CtxSwitches: int64(ru.Nvcsw + ru.Nivcsw),
+	}
+	u.Syscalls, _ = syscalls("self")
+	return u, nil
+}
@@ -0,0 +1,113 @@
+package procstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit of CPU times in /proc, fixed by the kernel ABI.
+const userHZ = 100
+
+// Process returns the usage of the process pid. CPU time has the 10ms
+// resolution of /proc/<pid>/stat, and context switches only count threads
+// that are still running.
+func Process(pid int) (Usage, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+	cpu, err := cpuTime(filepath.Join(dir, "stat"))
+	if err != nil {
+		return Usage{}, err
+	}
+	switches, err := ctxSwitches(dir)
+	if err != nil {
+		return Usage{}, err
+	}
+	calls, err := syscalls(strconv.Itoa(pid))
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{CPU: cpu, CtxSwitches: switches, Syscalls: calls}, nil
+}
+
+// cpuTime returns the user and system time from a /proc stat file.
+func cpuTime(path string) (time.Duration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces and parentheses, so fields are
+	// counted from the last parenthesis, after which the state is field 3
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("%s: malformed", path)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("%s: malformed", path)
+	}
+	var ticks int64
+	// utime and stime are fields 14 and 15
+	for _, field := range fields[11:13] {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * time.Second / userHZ, nil
+}
+
+// ctxSwitches returns the context switches of every thread of the process
+// in dir.
+func ctxSwitches(dir string) (int64, error) {
+	tasks, err := os.ReadDir(filepath.Join(dir, "task"))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, task := range tasks {
+		f, err := os.Open(filepath.Join(dir, "task", task.Name(), "status"))
+		if err != nil {
+			// The thread exited
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, _ := strings.Cut(scanner.Text(), ":")
+			if key == "voluntary_ctxt_switches" || key == "nonvoluntary_ctxt_switches" {
+				n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+				total += n
+			}
+		}
+		f.Close()
+	}
+	return total, nil
+}
+
+// syscalls returns the read and write system calls made by the process
+// named pid in /proc, such as "self".
+func syscalls(pid string) (int64, error) {
+	f, err := os.Open(filepath.Join("/proc", pid, "io"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var total int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ":")
+		if key == "syscr" || key == "syscw" {
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, scanner.Err()
+}
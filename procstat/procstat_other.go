@@ -0,0 +1,12 @@
+//go:build !linux
+
+package procstat
+
+// Process returns the usage of the process pid.
+func Process(pid int) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
+
+func syscalls(pid string) (int64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,79 @@
+package procstat
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// burn uses CPU until usage reports cpu more than before, spinning for at
+// most 10s of wall time, as the process may get only a share of a CPU. It
+// returns false if usage did not reach it.
+func burn(t *testing.T, usage func() (Usage, error), before Usage, cpu time.Duration) bool {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		for spin := time.Now(); time.Since(spin) < time.Millisecond; {
+		}
+		u, err := usage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.CPU-before.CPU >= cpu {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSelf(t *testing.T) {
+	before, err := Self()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !burn(t, Self, before, 20*time.Millisecond) {
+		t.Errorf("Expected 20ms CPU within 10s, got %v", mustSelf(t).Sub(before).CPU)
+	}
+	os.ReadFile("/proc/self/stat")
+	used := mustSelf(t).Sub(before)
+	if used.CtxSwitches < 0 || used.Syscalls < 0 {
+		t.Errorf("Counters went backwards: %+v", used)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	before, err := Process(os.Getpid())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := func() (Usage, error) { return Process(os.Getpid()) }
+	if !burn(t, process, before, 50*time.Millisecond) {
+		t.Errorf("Expected 50ms CPU within 10s")
+	}
+	for range 10 {
+		os.ReadFile("/proc/self/stat")
+	}
+	after, err := Process(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := after.Sub(before)
+	if used.Syscalls < 10 {
+		t.Errorf("Expected at least 10 syscalls, got %d", used.Syscalls)
+	}
+	if after.CtxSwitches <= 0 {
+		t.Errorf("Expected context switches, got %d", after.CtxSwitches)
+	}
+}
+
+func mustSelf(t *testing.T) Usage {
+	t.Helper()
+	u, err := Self()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,100 @@
+// Package profile writes CPU, block and mutex profiles and an execution
+// trace of a process, so the plugin side of a call can be profiled as well
+// as the host.
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// EnvDir is the environment variable that makes a plugin profile itself
+// into the directory it names.
+const EnvDir = "GOIPCBENCH_PROFILE_DIR"
+
+// Start starts profiling the process into dir, which it creates if needed.
+// The files are named after name and the process ID, such as
+// "unix.1234.cpu.pprof", so many processes can share dir. Stop writes
+// them.
+//
+// Block and mutex profiling record every event, which slows down the
+// process being profiled.
+func Start(dir, name string) (stop func() error, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%s.%d.", name, os.Getpid()))
+
+	cpuFile, err := os.Create(prefix + "cpu.pprof")
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		cpuFile.Close()
+		return nil, fmt.Errorf("start CPU profile: %w", err)
+	}
+	traceFile, err := os.Create(prefix + "trace")
+	if err != nil {
+		pprof.StopCPUProfile()
+		cpuFile.Close()
+		return nil, err
+	}
+	if err := trace.Start(traceFile); err != nil {
+		pprof.StopCPUProfile()
+		cpuFile.Close()
+		traceFile.Close()
+		return nil, fmt.Errorf("start trace: %w", err)
+	}
+	runtime.SetBlockProfileRate(1)
+	runtime.SetMutexProfileFraction(1)
+
+	return func() error {
+		trace.Stop()
+		pprof.StopCPUProfile()
+		errs := []error{traceFile.Close(), cpuFile.Close()}
+		for _, profile := range []string{"block", "mutex"} {
+			errs = append(errs, writeProfile(prefix+profile+".pprof", profile))
+		}
+		runtime.SetBlockProfileRate(0)
+		runtime.SetMutexProfileFraction(0)
+		return errors.Join(errs...)
+	}, nil
+}
+
+// FromEnv starts profiling into the directory named by EnvDir, if it is
+// set, and returns a function that stops it. Errors are reported on
+// standard error rather than stopping the process.
+func FromEnv(name string) (stop func()) {
+	dir := os.Getenv(EnvDir)
+	if dir == "" {
+		return func() {}
+	}
+	stopProfile, err := Start(dir, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start profiling: %v\n", err)
+		return func() {}
+	}
+	return func() {
+		if err := stopProfile(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write profiles: %v\n", err)
+		}
+	}
+}
+
+// writeProfile writes the named profile to path.
+func writeProfile(path, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s profile: %w", name, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,36 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	stop, err := Start(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suffix := range []string{"cpu.pprof", "block.pprof", "mutex.pprof", "trace"} {
+		path := filepath.Join(dir, fmt.Sprintf("test.%d.%s", os.Getpid(), suffix))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s is empty", path)
+		}
+	}
+}
+
+func TestFromEnvUnset(t *testing.T) {
+	t.Setenv(EnvDir, "")
+	FromEnv("test")()
+}
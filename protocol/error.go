@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Code classifies an error reply.
+type Code uint16
+
+const (
+	// CodeUnknownMethod means the peer does not implement the method.
+	CodeUnknownMethod Code = iota + 1
+	// CodeMalformed means the peer could not decode the message.
+	CodeMalformed
+	// CodeTooLarge means the message exceeded the transport's size limit.
+	CodeTooLarge
+	// CodeInternal means the handler failed.
+	CodeInternal
+	// CodeIncompatible means the peers cannot talk to each other.
+	CodeIncompatible
+	// CodeCanceled means the request was canceled before it completed.
+	CodeCanceled
+)
+
+func (c Code) String() string {
+	switch c {
+	case CodeUnknownMethod:
+		return "unknown method"
+	case CodeMalformed:
+		return "malformed message"
+	case CodeTooLarge:
+		return "message too large"
+	case CodeInternal:
+		return "internal error"
+	case CodeIncompatible:
+		return "incompatible peer"
+	case CodeCanceled:
+		return "canceled"
+	default:
+		return fmt.Sprintf("code(%d)", uint16(c))
+	}
+}
+
+// Error is an error sent in reply to a request instead of a response. It is
+// also returned by ReadMessage when a frame arrives intact but cannot be
+// decoded, so the reader can report it to the peer and carry on.
+type Error struct {
+	Code Code
+	Text string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Code, e.Text)
+}
+
+// Errorf returns an Error with code and formatted text.
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Text: fmt.Sprintf(format, args...)}
+}
+
+// maxErrorText is the longest error text that fits in a reply on every
+// transport, after the code.
+const maxErrorText = min(MaxMmapPayload, MaxStreamPayload) - 2
+
+// ErrorMessage returns the error reply to the request with id. err is sent as
+// the *Error it wraps if any and as CodeInternal otherwise. Text too long for
+// the smallest transport is truncated.
+func ErrorMessage(id uint32, err error) *Message {
+	var perr *Error
+	if !errors.As(err, &perr) {
+		perr = &Error{Code: CodeInternal, Text: err.Error()}
+	}
+	text := perr.Text
+	if len(text) > maxErrorText {
+		// Cut at the start of a rune so the text stays valid UTF-8
+		n := maxErrorText
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		text = text[:n]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(perr.Code))
+	payload = append(payload, text...)
+	return &Message{Type: TypeError, ID: id, Payload: payload}
+}
+
+// Err returns the *Error carried by a TypeError message and nil for any other
+// message.
+func (m *Message) Err() error {
+	if m.Type != TypeError {
+		return nil
+	}
+	if len(m.Payload) < 2 {
+		return Errorf(CodeMalformed, "short error payload")
+	}
+	return &Error{Code: Code(binary.BigEndian.Uint16(m.Payload)), Text: string(m.Payload[2:])}
+}
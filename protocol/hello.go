@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+const (
+	// Version is the protocol version this package speaks.
+	Version = 1
+	// MinVersion is the oldest protocol version this package can talk to.
+	MinVersion = 1
+)
+
+// baseTypes are the message types every peer must support.
+var baseTypes = []Type{TypeRequest, TypeResponse, TypeError}
+
+// Hello is the first message each side sends. The plugin sends its Hello as
+// soon as the transport is connected and the host answers with its own or,
+// if it refuses the plugin, with an error.
+type Hello struct {
+	Version    uint16
+	Name       string
+	Types      []Type
+	Methods    []string
+	MaxPayload uint32
+}
+
+// NewHello returns a Hello for this package's protocol version that supports
+// all message types.
+func NewHello(name string, maxPayload uint32, methods ...string) *Hello {
+	return &Hello{
+		Version:    Version,
+		Name:       name,
+		Types:      []Type{TypeRequest, TypeResponse, TypeError, TypeHello, TypeCancel, TypeShutdown, TypeStream, TypeChunk, TypeCredit},
+		Methods:    methods,
+		MaxPayload: maxPayload,
+	}
+}
+
+// Supports reports whether the peer that sent h serves method.
+func (h *Hello) Supports(method string) bool {
+	return slices.Contains(h.Methods, method)
+}
+
+// Compatible returns an *Error with CodeIncompatible if this package cannot
+// talk to the peer that sent h.
+func (h *Hello) Compatible() error {
+	if h.Version < MinVersion || h.Version > Version {
+		return Errorf(CodeIncompatible, "%q speaks protocol version %d but versions %d to %d are supported", h.Name, h.Version, MinVersion, Version)
+	}
+	for _, t := range baseTypes {
+		if !slices.Contains(h.Types, t) {
+			return Errorf(CodeIncompatible, "%q does not support %v messages", h.Name, t)
+		}
+	}
+	if h.MaxPayload == 0 {
+		return Errorf(CodeIncompatible, "%q does not accept any payload", h.Name)
+	}
+	return nil
+}
+
+// Message returns h encoded as a TypeHello message.
+func (h *Hello) Message() *Message {
+	payload := binary.BigEndian.AppendUint16(nil, h.Version)
+	payload = binary.BigEndian.AppendUint32(payload, h.MaxPayload)
+	payload = appendString(payload, h.Name)
+	payload = append(payload, byte(len(h.Types)))
+	for _, t := range h.Types {
+		payload = append(payload, byte(t))
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(h.Methods)))
+	for _, m := range h.Methods {
+		payload = appendString(payload, m)
+	}
+	return &Message{Type: TypeHello, Payload: payload}
+}
+
+// ParseHello decodes a TypeHello message. An error reply is returned as the
+// *Error it carries.
+func ParseHello(msg *Message) (*Hello, error) {
+	if err := msg.Err(); err != nil {
+		return nil, err
+	}
+	if msg.Type != TypeHello {
+		return nil, Errorf(CodeMalformed, "expected hello, got %v message", msg.Type)
+	}
+
+	b := msg.Payload
+	if len(b) < 6 {
+		return nil, Errorf(CodeMalformed, "short hello")
+	}
+	h := &Hello{
+		Version:    binary.BigEndian.Uint16(b),
+		MaxPayload: binary.BigEndian.Uint32(b[2:]),
+	}
+	b = b[6:]
+
+	var ok bool
+	if h.Name, b, ok = readString(b); !ok || len(b) < 1 {
+		return nil, Errorf(CodeMalformed, "short hello")
+	}
+	n := int(b[0])
+	if len(b) < 1+n+2 {
+		return nil, Errorf(CodeMalformed, "short hello")
+	}
+	for _, t := range b[1 : 1+n] {
+		h.Types = append(h.Types, Type(t))
+	}
+	b = b[1+n:]
+	n = int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	for range n {
+		var m string
+		if m, b, ok = readString(b); !ok {
+			return nil, Errorf(CodeMalformed, "short hello")
+		}
+		h.Methods = append(h.Methods, m)
+	}
+	return h, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+// HandshakePlugin sends the plugin's hello and returns the host's. It fails
+// if the host refuses the plugin or is itself incompatible.
+func HandshakePlugin(conn Conn, hello *Hello) (*Hello, error) {
+	if err := conn.WriteMessage(hello.Message()); err != nil {
+		return nil, err
+	}
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	host, err := ParseHello(msg)
+	if err != nil {
+		return nil, fmt.Errorf("host refused plugin: %w", err)
+	}
+	if err := host.Compatible(); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// HandshakeHost waits for the plugin's hello and answers with the host's. If
+// the plugin is incompatible the host tells it so and returns the reason.
+func HandshakeHost(conn Conn, hello *Hello) (*Hello, error) {
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	plugin, err := ParseHello(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := plugin.Compatible(); err != nil {
+		conn.WriteMessage(ErrorMessage(0, err))
+		return nil, err
+	}
+	if err := conn.WriteMessage(hello.Message()); err != nil {
+		return nil, err
+	}
+	return plugin, nil
+}
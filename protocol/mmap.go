@@ -0,0 +1,286 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// MmapSize is the size of the shared memory region used by MmapConn.
+const MmapSize = 4096
+
+const (
+	// The state word at stateOffset records which side wrote the message at
+	// msgOffset last. Only the side that is not the writer may read it.
+	stateOffset = 0
+	msgOffset   = 64
+
+	stateEmpty    = 0
+	stateToHost   = 1
+	stateToPlugin = 2
+
+	// Cancels do not wait for the message slot. Each direction has a word
+	// holding the ID of the request to cancel, or zero.
+	cancelToHostOffset   = 4
+	cancelToPluginOffset = 8
+
+	// Credits do not wait either. Each direction has a word holding the ID
+	// of a stream in its high half and the credits granted to it and not yet
+	// read in its low half.
+	creditToHostOffset   = 16
+	creditToPluginOffset = 24
+)
+
+// MaxMmapPayload is the largest method and payload that fit in the region.
+const MaxMmapPayload = MmapSize - msgOffset - headerSize
+
+// Side identifies which end of a connection a process is.
+type Side int
+
+const (
+	SideHost Side = iota
+	SidePlugin
+)
+
+// WaitStrategy is how an MmapConn waits for the peer to write.
+type WaitStrategy int
+
+const (
+	// WaitSpin busy waits without yielding.
+	WaitSpin WaitStrategy = iota
+	// WaitYield yields to the Go scheduler between checks.
+	WaitYield
+	// WaitSleep sleeps briefly between checks.
+	WaitSleep
+)
+
+func (w WaitStrategy) String() string {
+	switch w {
+	case WaitSpin:
+		return "spin"
+	case WaitYield:
+		return "yield"
+	case WaitSleep:
+		return "sleep"
+	default:
+		return fmt.Sprintf("wait(%d)", int(w))
+	}
+}
+
+// ParseWaitStrategy returns the WaitStrategy named s, as returned by String.
+func ParseWaitStrategy(s string) (WaitStrategy, error) {
+	for _, w := range []WaitStrategy{WaitSpin, WaitYield, WaitSleep} {
+		if w.String() == s {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown wait strategy %q", s)
+}
+
+// deadlineCheckInterval is how many spins pass between deadline checks, as
+// reading the clock costs more than checking the state word.
+const deadlineCheckInterval = 64
+
+// MmapConn is a Conn over a shared memory region. The region holds one
+// message at a time so a writer waits for the peer to read the previous
+// message before writing the next.
+type MmapConn struct {
+	data      []byte
+	state     *uint32
+	cancelIn  *uint32
+	cancelOut *uint32
+	creditIn  *uint64
+	creditOut *uint64
+	incoming  uint32
+	outgoing  uint32
+	wait      WaitStrategy
+	buf       []byte
+
+	// inPlace makes ReadMessage return payloads that alias the region.
+	// held records that the last message read still occupies it.
+	inPlace bool
+	held    bool
+
+	// deadline is in Unix nanoseconds, or zero for none.
+	deadline atomic.Int64
+	closed   atomic.Bool
+}
+
+// NewMmapConn returns a MmapConn for side over data, which must be at least
+// MmapSize bytes and shared with the peer.
+func NewMmapConn(data []byte, side Side, wait WaitStrategy) *MmapConn {
+	c := &MmapConn{
+		data:  data[:MmapSize],
+		state: (*uint32)(unsafe.Pointer(&data[stateOffset])),
+		wait:  wait,
+	}
+	toHost := (*uint32)(unsafe.Pointer(&data[cancelToHostOffset]))
+	toPlugin := (*uint32)(unsafe.Pointer(&data[cancelToPluginOffset]))
+	creditToHost := (*uint64)(unsafe.Pointer(&data[creditToHostOffset]))
+	creditToPlugin := (*uint64)(unsafe.Pointer(&data[creditToPluginOffset]))
+	if side == SideHost {
+		c.incoming, c.outgoing = stateToHost, stateToPlugin
+		c.cancelIn, c.cancelOut = toHost, toPlugin
+		c.creditIn, c.creditOut = creditToHost, creditToPlugin
+	} else {
+		c.incoming, c.outgoing = stateToPlugin, stateToHost
+		c.cancelIn, c.cancelOut = toPlugin, toHost
+		c.creditIn, c.creditOut = creditToPlugin, creditToHost
+	}
+	return c
+}
+
+// SetWaitStrategy changes how the connection waits for the peer.
+func (c *MmapConn) SetWaitStrategy(wait WaitStrategy) {
+	c.wait = wait
+}
+
+// SetInPlace makes ReadMessage return payloads that alias the region rather
+// than copies, so that a payload laid out to be read in place is never
+// copied. Such a payload is only valid until the next ReadMessage or
+// WriteMessage, and the peer cannot write again until then.
+func (c *MmapConn) SetInPlace(inPlace bool) {
+	c.inPlace = inPlace
+}
+
+// SetDeadline bounds how long reads and writes wait for the peer. A zero t
+// means wait forever.
+func (c *MmapConn) SetDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.deadline.Store(0)
+	} else {
+		c.deadline.Store(t.UnixNano())
+	}
+	return nil
+}
+
+// Close makes waits in progress and later fail with ErrClosed. The region
+// itself is left to its owner to unmap.
+func (c *MmapConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// WriteMessage copies msg into the region and hands it to the peer. A
+// TypeCancel or TypeCredit message is posted without waiting for the region
+// to be free. Credits for a stream replace any the peer has not read for
+// another.
+func (c *MmapConn) WriteMessage(msg *Message) error {
+	switch msg.Type {
+	case TypeCancel:
+		atomic.StoreUint32(c.cancelOut, msg.ID)
+		return nil
+	case TypeCredit:
+		n, err := msg.Credits()
+		if err != nil {
+			return err
+		}
+		for {
+			old := atomic.LoadUint64(c.creditOut)
+			credit := uint64(msg.ID)<<32 | uint64(n)
+			if uint32(old>>32) == msg.ID {
+				credit = old + uint64(n)
+			}
+			if atomic.CompareAndSwapUint64(c.creditOut, old, credit) {
+				return nil
+			}
+		}
+	}
+	if frameSize(msg) > MmapSize-msgOffset {
+		return fmt.Errorf("%d byte message: %w", frameSize(msg), ErrTooLarge)
+	}
+	buf, err := appendFrame(c.buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	c.buf = buf
+	return c.WriteFrame(buf)
+}
+
+// WriteFrame copies an already encoded frame into the region and hands it to
+// the peer. It is useful for testing how a peer handles malformed input.
+func (c *MmapConn) WriteFrame(frame []byte) error {
+	if len(frame) > MmapSize-msgOffset {
+		return fmt.Errorf("%d byte frame: %w", len(frame), ErrTooLarge)
+	}
+	for i := 0; atomic.LoadUint32(c.state) == c.outgoing; i++ {
+		if err := c.pause(i); err != nil {
+			return err
+		}
+	}
+	c.held = false
+	copy(c.data[msgOffset:], frame)
+	atomic.StoreUint32(c.state, c.outgoing)
+	return nil
+}
+
+// ReadMessage waits for the peer to write a message and decodes it. A frame
+// that cannot be decoded is returned as an *Error. A cancel posted by the peer
+// is returned as a TypeCancel message, and credits as a TypeCredit message,
+// once no message is waiting.
+func (c *MmapConn) ReadMessage() (*Message, error) {
+	c.release()
+	for i := 0; atomic.LoadUint32(c.state) != c.incoming; i++ {
+		if id := atomic.SwapUint32(c.cancelIn, 0); id != 0 {
+			return &Message{Type: TypeCancel, ID: id}, nil
+		}
+		if credit := atomic.SwapUint64(c.creditIn, 0); credit != 0 {
+			return CreditMessage(uint32(credit>>32), uint32(credit)), nil
+		}
+		if err := c.pause(i); err != nil {
+			return nil, err
+		}
+	}
+
+	var msg *Message
+	var err error
+	n := binary.BigEndian.Uint32(c.data[msgOffset:])
+	if n > MmapSize-msgOffset-4 {
+		err = Errorf(CodeTooLarge, "frame of %d bytes exceeds region", n)
+	} else {
+		msg, err = parseFrame(c.data[msgOffset+4:msgOffset+4+int(n)], c.inPlace)
+	}
+	if c.inPlace && err == nil && len(msg.Payload) > 0 {
+		// Keep the payload in the region until the next read or write
+		c.held = true
+		return msg, nil
+	}
+
+	// The message has been copied out so the region is free for the reply.
+	atomic.StoreUint32(c.state, stateEmpty)
+	return msg, err
+}
+
+// release frees the region if a message read in place still occupies it.
+func (c *MmapConn) release() {
+	if c.held {
+		c.held = false
+		atomic.StoreUint32(c.state, stateEmpty)
+	}
+}
+
+// pause waits before the next check of the region. i counts the checks made
+// so far. It returns os.ErrDeadlineExceeded once the deadline has passed and
+// ErrClosed once the connection is closed.
+func (c *MmapConn) pause(i int) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if deadline := c.deadline.Load(); deadline != 0 {
+		if (c.wait != WaitSpin || i%deadlineCheckInterval == 0) && time.Now().UnixNano() >= deadline {
+			return os.ErrDeadlineExceeded
+		}
+	}
+
+	switch c.wait {
+	case WaitYield:
+		runtime.Gosched()
+	case WaitSleep:
+		time.Sleep(100 * time.Nanosecond)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package protocol defines the messages exchanged between the host and a
+// plugin and how they are framed on each transport.
+//
+// Either side may send a request at any time it is waiting for a response, so
+// a plugin can call back into the host while it is handling a host request.
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Type identifies the kind of a message.
+type Type uint8
+
+const (
+	TypeRequest Type = iota + 1
+	TypeResponse
+	TypeError
+	TypeHello
+	// TypeCancel tells the peer the sender has given up on the request with
+	// its ID. It gets no reply.
+	TypeCancel
+	// TypeShutdown asks the plugin to stop taking requests, release its
+	// resources and exit. The plugin acknowledges it with an empty response
+	// before releasing anything.
+	TypeShutdown
+	// TypeStream is a request whose reply is streamed: any number of
+	// TypeChunk messages with its ID followed by a TypeResponse, the end
+	// marker, or a TypeError.
+	TypeStream
+	// TypeChunk is part of the reply to a TypeStream request.
+	TypeChunk
+	// TypeCredit lets the peer streaming the reply to the request with its
+	// ID send that many more chunks, so a slow receiver is not overrun. The
+	// sender of a stream request grants the first credits right after it.
+	// It gets no reply.
+	TypeCredit
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeRequest:
+		return "request"
+	case TypeResponse:
+		return "response"
+	case TypeError:
+		return "error"
+	case TypeHello:
+		return "hello"
+	case TypeCancel:
+		return "cancel"
+	case TypeShutdown:
+		return "shutdown"
+	case TypeStream:
+		return "stream"
+	case TypeChunk:
+		return "chunk"
+	case TypeCredit:
+		return "credit"
+	default:
+		return fmt.Sprintf("type(%d)", uint8(t))
+	}
+}
+
+// Message is a single protocol message. Responses and errors carry the ID of
+// the request they answer.
+type Message struct {
+	Type    Type
+	ID      uint32
+	Method  string
+	Payload []byte
+}
+
+// headerSize is the size of a frame before the method and payload: a uint32
+// length of the rest of the frame, the type, the ID and the method length.
+const headerSize = 4 + 1 + 4 + 1
+
+var (
+	// ErrTooLarge is returned when a message does not fit in the transport.
+	ErrTooLarge = errors.New("message too large")
+
+	// ErrNoDeadline is returned by SetDeadline when the transport cannot
+	// time out.
+	ErrNoDeadline = errors.New("transport does not support deadlines")
+
+	// ErrClosed is returned by operations on a closed connection.
+	ErrClosed = errors.New("use of closed connection")
+)
+
+// Conn sends and receives messages over a transport.
+type Conn interface {
+	WriteMessage(msg *Message) error
+	ReadMessage() (*Message, error)
+
+	// SetDeadline makes reads and writes that would wait past t fail with
+	// os.ErrDeadlineExceeded. A zero t means no deadline.
+	SetDeadline(t time.Time) error
+
+	// Close makes reads and writes in progress and later fail.
+	Close() error
+}
+
+// frameSize returns the encoded size of msg.
+func frameSize(msg *Message) int {
+	return headerSize + len(msg.Method) + len(msg.Payload)
+}
+
+// appendFrame appends the encoding of msg to buf.
+func appendFrame(buf []byte, msg *Message) ([]byte, error) {
+	if len(msg.Method) > 255 {
+		return nil, fmt.Errorf("method %q: %w", msg.Method, ErrTooLarge)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(frameSize(msg)-4))
+	buf = append(buf, byte(msg.Type))
+	buf = binary.BigEndian.AppendUint32(buf, msg.ID)
+	buf = append(buf, byte(len(msg.Method)))
+	buf = append(buf, msg.Method...)
+	buf = append(buf, msg.Payload...)
+	return buf, nil
+}
+
+// parseFrame decodes the body of a frame, that is everything after the length
+// prefix. The returned message does not retain body unless alias is set, in
+// which case its payload is part of body. Decoding failures are returned as
+// an *Error with CodeMalformed.
+func parseFrame(body []byte, alias bool) (*Message, error) {
+	if len(body) < headerSize-4 {
+		return nil, Errorf(CodeMalformed, "short frame: %d bytes", len(body))
+	}
+	msg := &Message{
+		Type: Type(body[0]),
+		ID:   binary.BigEndian.Uint32(body[1:5]),
+	}
+	methodLen := int(body[5])
+	body = body[6:]
+	if len(body) < methodLen {
+		return nil, Errorf(CodeMalformed, "method length %d exceeds frame", methodLen)
+	}
+	msg.Method = string(body[:methodLen])
+	switch {
+	case len(body) == methodLen:
+	case alias:
+		msg.Payload = body[methodLen:len(body):len(body)]
+	default:
+		msg.Payload = append([]byte(nil), body[methodLen:]...)
+	}
+	return msg, nil
+}
+
+// CreditMessage returns a TypeCredit message granting n chunks of the
+// stream with id.
+func CreditMessage(id, n uint32) *Message {
+	return &Message{Type: TypeCredit, ID: id, Payload: binary.BigEndian.AppendUint32(nil, n)}
+}
+
+// Credits returns the number of chunks a TypeCredit message grants.
+func (m *Message) Credits() (uint32, error) {
+	if m.Type != TypeCredit || len(m.Payload) != 4 {
+		return 0, Errorf(CodeMalformed, "not a credit: %v message with %d byte payload", m.Type, len(m.Payload))
+	}
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// Caller calls methods on the other side, as host.Plugin and host.Client
+// do. Code generated by goipcgen makes its calls with a Caller.
+type Caller interface {
+	Call(ctx context.Context, method string, payload []byte) ([]byte, error)
+}
+
+// Handler answers a request from the peer. A returned error is sent to the
+// peer as an error reply.
+type Handler func(method string, payload []byte) ([]byte, error)
+
+// Call sends req and waits for its response. Requests the peer makes while
+// req is outstanding are answered with handler. A nil handler answers them
+// with CodeUnknownMethod. If the peer replies with an error, Call returns it
+// as an *Error.
+//
+// A plugin calling back into the host while serving a request uses the ID of
+// that request, so that if the host cancels the request Call returns a
+// CodeCanceled error instead of waiting for a reply that will not come.
+func Call(conn Conn, req *Message, handler Handler) (*Message, error) {
+	if err := conn.WriteMessage(req); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case TypeResponse:
+			if msg.ID != req.ID {
+				return nil, fmt.Errorf("response ID %d does not match request ID %d", msg.ID, req.ID)
+			}
+			return msg, nil
+		case TypeError:
+			// A peer that could not decode the request cannot know its ID
+			if msg.ID != req.ID && msg.ID != 0 {
+				return nil, fmt.Errorf("error ID %d does not match request ID %d", msg.ID, req.ID)
+			}
+			return nil, msg.Err()
+		case TypeRequest:
+			if err := conn.WriteMessage(Answer(msg, handler)); err != nil {
+				return nil, err
+			}
+		case TypeCancel:
+			// Cancels for anything else are stale
+			if msg.ID == req.ID {
+				return nil, Errorf(CodeCanceled, "%s canceled by peer", req.Method)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected message %v", msg.Type)
+		}
+	}
+}
+
+// Answer returns the reply to req from handler. A nil handler answers with
+// CodeUnknownMethod.
+func Answer(req *Message, handler Handler) *Message {
+	if handler == nil {
+		return ErrorMessage(req.ID, Errorf(CodeUnknownMethod, "%s", req.Method))
+	}
+	payload, err := handler(req.Method, req.Payload)
+	if err != nil {
+		return ErrorMessage(req.ID, err)
+	}
+	return &Message{Type: TypeResponse, ID: req.ID, Method: req.Method, Payload: payload}
+}
@@ -0,0 +1,340 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestStreamConnRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	conn := NewStreamConn(&buf, &buf)
+
+	msgs := []*Message{
+		{Type: TypeRequest, ID: 1, Method: "ping"},
+		{Type: TypeResponse, ID: 1, Payload: []byte("pong")},
+		{Type: TypeRequest, ID: 2, Method: "lookup", Payload: bytes.Repeat([]byte("x"), 10000)},
+	}
+	for _, msg := range msgs {
+		if err := conn.WriteMessage(msg); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+
+	for _, want := range msgs {
+		got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if got.Type != want.Type || got.ID != want.ID || got.Method != want.Method || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if _, err := conn.ReadMessage(); err != io.EOF {
+		t.Fatalf("ReadMessage at end: got %v, want io.EOF", err)
+	}
+}
+
+func TestMmapConnTooLarge(t *testing.T) {
+	conn := NewMmapConn(make([]byte, MmapSize), SideHost, WaitSpin)
+	msg := &Message{Type: TypeRequest, Method: "x", Payload: make([]byte, MaxMmapPayload)}
+	if err := conn.WriteMessage(msg); err == nil {
+		t.Fatalf("WriteMessage succeeded with oversized message")
+	}
+}
+
+func TestMmapConnInPlace(t *testing.T) {
+	data := make([]byte, MmapSize)
+	host := NewMmapConn(data, SideHost, WaitYield)
+	plugin := NewMmapConn(data, SidePlugin, WaitYield)
+	plugin.SetInPlace(true)
+
+	if err := host.WriteMessage(&Message{Type: TypeRequest, ID: 1, Method: "echo", Payload: []byte("hello")}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	req, err := plugin.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if string(req.Payload) != "hello" || &req.Payload[0] != &data[msgOffset+headerSize+len("echo")] {
+		t.Fatalf("payload %q is not read in place", req.Payload)
+	}
+
+	// The region stays taken while the plugin holds the payload
+	if state := atomic.LoadUint32(plugin.state); state != stateToPlugin {
+		t.Fatalf("region state is %d while the payload is held", state)
+	}
+
+	// Replying with the payload itself frees the region for the next request
+	if err := plugin.WriteMessage(&Message{Type: TypeResponse, ID: 1, Payload: req.Payload}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	resp, err := host.ReadMessage()
+	if err != nil || string(resp.Payload) != "hello" {
+		t.Fatalf("ReadMessage: got %+v, %v", resp, err)
+	}
+	if err := host.WriteMessage(&Message{Type: TypeRequest, ID: 2, Method: "ping"}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if req, err := plugin.ReadMessage(); err != nil || req.ID != 2 || req.Payload != nil {
+		t.Fatalf("ReadMessage: got %+v, %v", req, err)
+	}
+}
+
+func TestMmapConnCredit(t *testing.T) {
+	data := make([]byte, MmapSize)
+	host := NewMmapConn(data, SideHost, WaitYield)
+	plugin := NewMmapConn(data, SidePlugin, WaitYield)
+
+	// Credits for one stream add up until read, and credits for another
+	// replace them
+	for _, msg := range []*Message{CreditMessage(5, 3), CreditMessage(5, 2)} {
+		if err := host.WriteMessage(msg); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	if msg, err := plugin.ReadMessage(); err != nil || msg.Type != TypeCredit || msg.ID != 5 {
+		t.Fatalf("ReadMessage: got %+v, %v", msg, err)
+	} else if n, err := msg.Credits(); err != nil || n != 5 {
+		t.Fatalf("Credits: got %d, %v", n, err)
+	}
+	host.WriteMessage(CreditMessage(5, 1))
+	host.WriteMessage(CreditMessage(6, 4))
+	if msg, err := plugin.ReadMessage(); err != nil || msg.ID != 6 {
+		t.Fatalf("ReadMessage: got %+v, %v", msg, err)
+	} else if n, _ := msg.Credits(); n != 4 {
+		t.Fatalf("Credits: got %d, want 4", n)
+	}
+}
+
+// testCallback runs a host calling "lookup" on a plugin that calls back into
+// the host for the value.
+func testCallback(t *testing.T, hostConn, pluginConn Conn) {
+	go func() {
+		req, err := pluginConn.ReadMessage()
+		if err != nil {
+			t.Errorf("plugin ReadMessage: %v", err)
+			return
+		}
+		value, err := Call(pluginConn, &Message{Type: TypeRequest, ID: 1, Method: "kv.get", Payload: req.Payload}, nil)
+		if err != nil {
+			t.Errorf("plugin Call: %v", err)
+			return
+		}
+		if err := pluginConn.WriteMessage(&Message{Type: TypeResponse, ID: req.ID, Payload: value.Payload}); err != nil {
+			t.Errorf("plugin WriteMessage: %v", err)
+		}
+	}()
+
+	handler := func(method string, payload []byte) ([]byte, error) {
+		if method != "kv.get" || string(payload) != "color" {
+			t.Errorf("unexpected callback %s(%s)", method, payload)
+		}
+		return []byte("blue"), nil
+	}
+	resp, err := Call(hostConn, &Message{Type: TypeRequest, ID: 7, Method: "lookup", Payload: []byte("color")}, handler)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if resp.ID != 7 || string(resp.Payload) != "blue" {
+		t.Fatalf("got %+v, want ID 7 and payload blue", resp)
+	}
+}
+
+func TestStreamConnCallback(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	testCallback(t, NewStreamConn(a, a), NewStreamConn(b, b))
+}
+
+func TestMmapConnCallback(t *testing.T) {
+	data := make([]byte, MmapSize)
+	testCallback(t, NewMmapConn(data, SideHost, WaitYield), NewMmapConn(data, SidePlugin, WaitYield))
+}
+
+func TestStreamConnLegacyText(t *testing.T) {
+	// The old line based protocol reads as an absurd frame length
+	conn := NewStreamConn(strings.NewReader("ping\nping\n"), io.Discard)
+
+	_, err := conn.ReadMessage()
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != CodeTooLarge {
+		t.Fatalf("ReadMessage: got %v, want CodeTooLarge error", err)
+	}
+
+	if _, err := conn.ReadMessage(); err == nil || errors.As(err, &perr) {
+		t.Fatalf("ReadMessage after oversized frame: got %v, want fatal error", err)
+	}
+}
+
+func TestCallErrorReply(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	hostConn, pluginConn := NewStreamConn(a, a), NewStreamConn(b, b)
+
+	go func() {
+		req, err := pluginConn.ReadMessage()
+		if err != nil {
+			t.Errorf("plugin ReadMessage: %v", err)
+			return
+		}
+		pluginConn.WriteMessage(ErrorMessage(req.ID, Errorf(CodeUnknownMethod, "no such method %s", req.Method)))
+	}()
+
+	_, err := Call(hostConn, &Message{Type: TypeRequest, ID: 3, Method: "bogus"}, nil)
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("Call: got %v, want *Error", err)
+	}
+	if perr.Code != CodeUnknownMethod || perr.Text != "no such method bogus" {
+		t.Fatalf("Call: got %+v", perr)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	wrapped := fmt.Errorf("lookup: %w", Errorf(CodeUnknownMethod, "no such key"))
+	if err := ErrorMessage(1, wrapped).Err(); err.(*Error).Code != CodeUnknownMethod || err.(*Error).Text != "no such key" {
+		t.Errorf("Wrapped *Error: got %v", err)
+	}
+
+	// A long message must still fit the shared memory region
+	long := errors.New(strings.Repeat("é", MmapSize))
+	msg := ErrorMessage(2, long)
+	conn := NewMmapConn(make([]byte, MmapSize), SideHost, WaitSpin)
+	if err := conn.WriteMessage(msg); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	perr := msg.Err().(*Error)
+	if perr.Code != CodeInternal || !utf8.ValidString(perr.Text) || !strings.HasPrefix(long.Error(), perr.Text) {
+		t.Errorf("Truncated error: got code %v and %d bytes of text", perr.Code, len(perr.Text))
+	}
+}
+
+func TestHelloRoundTrip(t *testing.T) {
+	want := NewHello("test", 1234, "ping", "lookup")
+	got, err := ParseHello(want.Message())
+	if err != nil {
+		t.Fatalf("ParseHello: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if err := got.Compatible(); err != nil {
+		t.Fatalf("Compatible: %v", err)
+	}
+}
+
+func TestHandshakeIncompatiblePlugin(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	hostConn, pluginConn := NewStreamConn(a, a), NewStreamConn(b, b)
+
+	pluginErr := make(chan error, 1)
+	go func() {
+		hello := NewHello("future", 100)
+		hello.Version = Version + 1
+		_, err := HandshakePlugin(pluginConn, hello)
+		pluginErr <- err
+	}()
+
+	_, err := HandshakeHost(hostConn, NewHello("host", 100))
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != CodeIncompatible {
+		t.Fatalf("HandshakeHost: got %v, want CodeIncompatible error", err)
+	}
+	if !strings.Contains(err.Error(), `"future" speaks protocol version 2`) {
+		t.Errorf("HandshakeHost error does not explain mismatch: %v", err)
+	}
+
+	// The plugin learns why it was refused
+	if err := <-pluginErr; !errors.As(err, &perr) || perr.Code != CodeIncompatible {
+		t.Fatalf("HandshakePlugin: got %v, want CodeIncompatible error", err)
+	}
+}
+
+func TestHandshakeIncompatibleHost(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	hostConn, pluginConn := NewStreamConn(a, a), NewStreamConn(b, b)
+
+	go func() {
+		hello := NewHello("old host", 100)
+		hello.Types = []Type{TypeRequest}
+		HandshakeHost(hostConn, hello)
+	}()
+
+	_, err := HandshakePlugin(pluginConn, NewHello("plugin", 100))
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != CodeIncompatible {
+		t.Fatalf("HandshakePlugin: got %v, want CodeIncompatible error", err)
+	}
+}
+
+func TestStopperWaitsForRequest(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := NewStreamConn(b, b)
+	stop := NewStopper(conn)
+
+	// A request read before Stop is handled and answered
+	go NewStreamConn(a, a).WriteMessage(&Message{Type: TypeRequest, ID: 1, Method: "ping"})
+	msg, err := conn.ReadMessage()
+	if stop.Stopped(err) {
+		t.Fatalf("Stopped before Stop: %v", err)
+	}
+	stop.Begin()
+	stopped := make(chan struct{})
+	go func() {
+		stop.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a request was being handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	replies := make(chan *Message, 1)
+	go func() {
+		reply, _ := NewStreamConn(a, a).ReadMessage()
+		replies <- reply
+	}()
+	if err := conn.WriteMessage(&Message{Type: TypeResponse, ID: msg.ID, Payload: []byte("pong")}); err != nil {
+		t.Fatalf("Failed to write reply: %v", err)
+	}
+	if !stop.End() {
+		t.Fatal("End did not report stopping")
+	}
+	<-stopped
+	if reply := <-replies; reply == nil || string(reply.Payload) != "pong" {
+		t.Fatalf("Unexpected reply: %v", reply)
+	}
+}
+
+func TestStopperInterruptsRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := NewStreamConn(b, b)
+	stop := NewStopper(conn)
+
+	time.AfterFunc(20*time.Millisecond, stop.Stop)
+	_, err := conn.ReadMessage()
+	if !stop.Stopped(err) {
+		t.Fatalf("Expected read to be stopped, got %v", err)
+	}
+}
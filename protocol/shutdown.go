@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past that makes blocked I/O return.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Stopper lets a plugin stop its message loop from another goroutine, such as
+// on a signal, without cutting off the request it is handling. The loop
+// brackets handling each message with Begin and End:
+//
+//	for {
+//		msg, err := conn.ReadMessage()
+//		if stop.Stopped(err) {
+//			return
+//		}
+//		stop.Begin()
+//		// handle msg and write the reply
+//		if stop.End() {
+//			return
+//		}
+//	}
+type Stopper struct {
+	conn     Conn
+	mu       sync.Mutex
+	stopping atomic.Bool
+}
+
+// NewStopper returns a Stopper for the loop reading conn.
+func NewStopper(conn Conn) *Stopper {
+	return &Stopper{conn: conn}
+}
+
+// Notify makes the first of the signals sig stop the loop.
+func (s *Stopper) Notify(sig ...os.Signal) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+	go func() {
+		<-signals
+		s.Stop()
+	}()
+}
+
+// Stop waits for the message being handled, if any, to be answered and then
+// makes the loop's read fail so that Stopped reports true. The conn must
+// support deadlines.
+func (s *Stopper) Stop() {
+	// A loop handling a message returns once it is answered
+	s.stopping.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetDeadline(aLongTimeAgo)
+}
+
+// Stopped reports whether err from reading the conn means the loop should
+// return, either because Stop interrupted the read or because the peer
+// closed the connection.
+func (s *Stopper) Stopped(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, io.EOF) || (s.stopping.Load() && errors.Is(err, os.ErrDeadlineExceeded))
+}
+
+// Begin marks the start of handling a message. Stop waits for the matching
+// End. A message read just before Stop is still handled.
+func (s *Stopper) Begin() {
+	s.mu.Lock()
+	if s.stopping.Load() {
+		s.conn.SetDeadline(time.Time{})
+	}
+}
+
+// End marks the reply to the message as written and reports whether the loop
+// should return.
+func (s *Stopper) End() bool {
+	defer s.mu.Unlock()
+	return s.stopping.Load()
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// maxStreamFrame bounds the size of a frame read from a stream so a corrupt
+// length prefix cannot exhaust memory.
+const maxStreamFrame = 16 << 20
+
+// MaxStreamPayload is the largest method and payload a StreamConn accepts.
+const MaxStreamPayload = maxStreamFrame - (headerSize - 4)
+
+// StreamConn is a Conn over a byte stream such as a pipe or socket. Each
+// message is written as a length-prefixed frame.
+type StreamConn struct {
+	rd   io.Reader
+	r    *bufio.Reader
+	w    io.Writer
+	wbuf []byte
+	rbuf []byte
+
+	// A read interrupted by a deadline resumes from hdrN and bodyN.
+	hdr   [4]byte
+	hdrN  int
+	bodyN int
+
+	// err is set once the stream can no longer be split into frames.
+	err error
+}
+
+var (
+	// errOutOfSync is returned by ReadMessage after an oversized frame has
+	// been rejected, since the rest of the stream cannot be trusted.
+	errOutOfSync = errors.New("stream out of sync after oversized frame")
+
+	// errPartialWrite is returned by WriteMessage after a write stopped part
+	// way through a frame.
+	errPartialWrite = errors.New("stream out of sync after partial write")
+)
+
+// NewStreamConn returns a StreamConn that reads from r and writes to w.
+func NewStreamConn(r io.Reader, w io.Writer) *StreamConn {
+	return &StreamConn{rd: r, r: bufio.NewReader(r), w: w}
+}
+
+// SetDeadline sets the read and write deadline of the underlying reader and
+// writer. It returns ErrNoDeadline if either does not support deadlines.
+func (c *StreamConn) SetDeadline(t time.Time) error {
+	rd, ok := c.rd.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return ErrNoDeadline
+	}
+	wd, ok := c.w.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return ErrNoDeadline
+	}
+	if err := rd.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return wd.SetWriteDeadline(t)
+}
+
+// Close closes the underlying reader and writer if they are io.Closers.
+func (c *StreamConn) Close() error {
+	var err error
+	if closer, ok := c.rd.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if closer, ok := c.w.(io.Closer); ok && any(c.w) != any(c.rd) {
+		if werr := closer.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// WriteMessage writes msg as a single frame.
+func (c *StreamConn) WriteMessage(msg *Message) error {
+	buf, err := appendFrame(c.wbuf[:0], msg)
+	if err != nil {
+		return err
+	}
+	c.wbuf = buf
+	return c.WriteFrame(buf)
+}
+
+// WriteFrame writes an already encoded frame. It is useful for testing how a
+// peer handles malformed input.
+func (c *StreamConn) WriteFrame(frame []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	n, err := c.w.Write(frame)
+	if err != nil && n > 0 {
+		c.err = errPartialWrite
+	}
+	return err
+}
+
+// ReadMessage reads the next frame. It returns io.EOF if the stream ends
+// cleanly between frames. A frame that cannot be decoded is returned as an
+// *Error. After an oversized frame the stream is unusable and later calls
+// fail.
+func (c *StreamConn) ReadMessage() (*Message, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	for c.hdrN < len(c.hdr) {
+		n, err := c.r.Read(c.hdr[c.hdrN:])
+		c.hdrN += n
+		if err != nil {
+			if err == io.EOF && c.hdrN > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	n := int(binary.BigEndian.Uint32(c.hdr[:]))
+	if n > maxStreamFrame {
+		// Skipping the frame could block forever on a corrupt length so give
+		// up on the stream instead
+		c.err = errOutOfSync
+		return nil, Errorf(CodeTooLarge, "frame of %d bytes exceeds limit of %d", n, maxStreamFrame)
+	}
+
+	if cap(c.rbuf) < n {
+		c.rbuf = make([]byte, n)
+	}
+	body := c.rbuf[:n]
+	for c.bodyN < n {
+		m, err := c.r.Read(body[c.bodyN:])
+		c.bodyN += m
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	c.hdrN, c.bodyN = 0, 0
+	return parseFrame(body, false)
+}
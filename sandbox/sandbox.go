@@ -0,0 +1,55 @@
+// Package sandbox restricts what a plugin process can do once it has
+// connected to the host, so that a plugin that is compromised or misbehaves
+// can do little beyond answering requests. It combines Landlock, which
+// denies access to the filesystem and the network, with a seccomp filter
+// that kills the process if it makes a system call it does not need.
+//
+// Both are Linux features, and a sandbox cannot be lifted once applied.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// ErrUnsupported is returned on systems where a process cannot be
+// sandboxed.
+var ErrUnsupported = errors.New("sandboxing is not supported on " + runtime.GOOS + "/" + runtime.GOARCH)
+
+// Policy is what a sandboxed process may still do.
+type Policy struct {
+	// Read are files and directories the process may read, including
+	// everything beneath them, and Write those it may also write to. It
+	// may not open any other files.
+	Read, Write []string
+
+	// Syscalls are the system calls the process may make beyond those the
+	// Go runtime needs, as numbers such as syscall.SYS_OPENAT.
+	Syscalls []uintptr
+}
+
+// transportSyscalls maps each transport to the system calls it needs once
+// connected beyond those of the runtime. The descriptor transports read and
+// write through the network poller, so they may use it freely. The mmap
+// transport only waits on futexes or sleeps, so its plugin may read, write
+// and wait only on the poller's own descriptors, which its timers use. What
+// a transport no longer needs once connected, such as accept and openat, is
+// denied.
+var transportSyscalls = map[string][]uintptr{
+	"stdio": pollerSyscalls,
+	"tcp":   pollerSyscalls,
+	"unix":  pollerSyscalls,
+	"mmap":  nil,
+}
+
+// Transport returns the policy for a plugin that only talks to the host over
+// transport once connected: "stdio", "tcp", "unix" or "mmap". It may not
+// open any files.
+func Transport(transport string) (Policy, error) {
+	syscalls, ok := transportSyscalls[transport]
+	if !ok {
+		return Policy{}, fmt.Errorf("no sandbox policy for transport %q", transport)
+	}
+	return Policy{Syscalls: syscalls}, nil
+}
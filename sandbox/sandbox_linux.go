@@ -0,0 +1,289 @@
+//go:build amd64 || arm64
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Landlock system calls, which are numbered the same on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+)
+
+const (
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+	prSetNoNewPrivs              = 38
+	oPath                        = 0x200000
+)
+
+// Landlock filesystem access rights.
+const (
+	accessExecute   = 1 << 0
+	accessWriteFile = 1 << 1
+	accessReadFile  = 1 << 2
+	accessReadDir   = 1 << 3
+	accessTruncate  = 1 << 14
+	accessIoctlDev  = 1 << 15
+
+	// accessFile are the rights that apply to files rather than
+	// directories.
+	accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate | accessIoctlDev
+	// accessRead are the rights to read.
+	accessRead = accessExecute | accessReadFile | accessReadDir
+)
+
+// landlockFS are the filesystem rights each Landlock ABI version handles.
+// Version 1 has the first 13, versions 2, 3 and 5 each add one.
+var landlockFS = []uint64{1: 1<<13 - 1, 2: 1<<14 - 1, 3: 1<<15 - 1, 4: 1<<15 - 1, 5: 1<<16 - 1}
+
+// landlockNet are the network rights handled from ABI version 4: binding and
+// connecting TCP sockets. landlockScoped are the scopes from version 6:
+// abstract Unix sockets and signals outside the sandbox.
+const (
+	landlockNet    = 1<<0 | 1<<1
+	landlockScoped = 1<<0 | 1<<1
+)
+
+// Apply sandboxes every thread of the process with p, first with Landlock
+// and then with seccomp.
+func Apply(p Policy) error {
+	// Landlock hides the descriptors seccomp needs to find
+	poller, err := pollerFDs()
+	if err != nil {
+		return err
+	}
+	if err := Landlock(p.Read, p.Write); err != nil {
+		return err
+	}
+	return seccomp(p.Syscalls, poller)
+}
+
+// Landlock restricts every thread of the process to reading the files and
+// directories in read and writing those in write, and forbids binding or
+// connecting TCP sockets, connecting to abstract Unix sockets outside the
+// sandbox and signalling other processes, as far as the kernel's Landlock
+// supports. Descriptors the process already has are unaffected.
+//
+// Restricting every thread needs a program built without cgo.
+func Landlock(read, write []string) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not available: %w", errno)
+	}
+	version := min(int(abi), len(landlockFS)-1)
+
+	// The attribute grows with the ABI version
+	var attr [3]uint64
+	attr[0] = landlockFS[version]
+	size := 8
+	if abi >= 4 {
+		attr[1] = landlockNet
+		size = 16
+	}
+	if abi >= 6 {
+		attr[2] = landlockScoped
+		size = 24
+	}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), uintptr(size), 0)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, path := range read {
+		if err := addPathRule(int(fd), path, accessRead&landlockFS[version]); err != nil {
+			return err
+		}
+	}
+	for _, path := range write {
+		if err := addPathRule(int(fd), path, landlockFS[version]); err != nil {
+			return err
+		}
+	}
+
+	if err := allThreads(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); err != nil {
+		return fmt.Errorf("set no new privileges: %w", err)
+	}
+	if err := allThreads(sysLandlockRestrictSelf, fd, 0, 0); err != nil {
+		return fmt.Errorf("restrict to landlock ruleset: %w", err)
+	}
+	return nil
+}
+
+// addPathRule allows access to path and everything beneath it in the
+// ruleset fd.
+func addPathRule(fd int, path string, access uint64) error {
+	f, err := os.OpenFile(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		access &= accessFile
+	}
+
+	// struct landlock_path_beneath_attr is packed
+	var attr [12]byte
+	binary.NativeEndian.PutUint64(attr[:], access)
+	binary.NativeEndian.PutUint32(attr[8:], uint32(f.Fd()))
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(fd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add landlock rule for %s: %w", path, errno)
+	}
+	return nil
+}
+
+// allThreads makes a system call on every thread of the process.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return errors.New("a program built with cgo cannot restrict every thread; build it with CGO_ENABLED=0")
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Seccomp filter return values and the flag that applies a filter to every
+// thread.
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1 << 0
+	seccompRetKillProcess  = 0x80000000
+	seccompRetAllow        = 0x7fff0000
+)
+
+// Offsets into struct seccomp_data. The first argument is read by its low
+// 32 bits, which is all of a descriptor.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+// Seccomp installs a filter on every thread of the process that kills it
+// if it makes a system call other than those the Go runtime needs and
+// syscalls. Unless syscalls allows them, the runtime's network poller may
+// only read, write and wait on its own descriptors, and the runtime may
+// write a crash report to standard error.
+func Seccomp(syscalls []uintptr) error {
+	poller, err := pollerFDs()
+	if err != nil {
+		return err
+	}
+	return seccomp(syscalls, poller)
+}
+
+// pollerFDs starts the runtime's network poller if it has not started and
+// returns its descriptors: its epoll instance and the eventfd it wakes
+// itself with.
+func pollerFDs() ([]uint32, error) {
+	// Timers rely on the network poller, so starting one starts it
+	time.Sleep(time.Nanosecond)
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil, fmt.Errorf("find network poller: %w", err)
+	}
+	var fds []uint32
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err != nil {
+			continue
+		}
+		if target == "anon_inode:[eventpoll]" || target == "anon_inode:[eventfd]" {
+			fd, err := strconv.ParseUint(entry.Name(), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("find network poller: %w", err)
+			}
+			fds = append(fds, uint32(fd))
+		}
+	}
+	if len(fds) == 0 {
+		return nil, errors.New("find network poller: no epoll descriptor")
+	}
+	return fds, nil
+}
+
+// seccomp installs the filter Seccomp describes, with poller the network
+// poller's descriptors.
+func seccomp(syscalls []uintptr, poller []uint32) error {
+	allowed := slices.Concat(runtimeSyscalls, syscalls)
+	slices.Sort(allowed)
+	allowed = slices.Compact(allowed)
+	// The poller's system calls are checked for its descriptors unless they
+	// are allowed outright
+	var checked []uintptr
+	for _, nr := range pollerSyscalls {
+		if !slices.Contains(allowed, nr) {
+			checked = append(checked, nr)
+		}
+	}
+	fds := append(poller, uint32(syscall.Stderr))
+
+	filter := []syscall.SockFilter{
+		// Only system calls made with the architecture's native ABI are
+		// numbered as expected
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: seccompDataArch},
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, K: auditArch},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetKillProcess},
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: seccompDataNr},
+	}
+	for i, nr := range allowed {
+		// Jump past the rest of the list, the checked calls and the kill to
+		// the allow
+		filter = append(filter, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: uint8(len(allowed) - i + len(checked)), K: uint32(nr)})
+	}
+	for i, nr := range checked {
+		// Jump past the rest of the checked calls, the kill and the allow to
+		// the descriptor check
+		filter = append(filter, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: uint8(len(checked) - i + 1), K: uint32(nr)})
+	}
+	filter = append(filter,
+		syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetKillProcess},
+		syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetAllow},
+		syscall.SockFilter{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: seccompDataArg0},
+	)
+	for i, fd := range fds {
+		filter = append(filter, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: uint8(len(fds) - i), K: fd})
+	}
+	filter = append(filter,
+		syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetKillProcess},
+		syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: seccompRetAllow},
+	)
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// The filter is synchronised to the other threads from this one, which
+	// must not be allowed new privileges
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no new privileges: %w", errno)
+	}
+	tid, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("install seccomp filter: thread %d cannot be synchronised", tid)
+	}
+	return nil
+}
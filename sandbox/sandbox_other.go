@@ -0,0 +1,27 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+// pollerSyscalls are the system calls of the network poller, which are not
+// filtered here.
+var pollerSyscalls []uintptr
+
+// Landlock restricts every thread of the process to reading the files and
+// directories in read and writing those in write. It is only supported on
+// Linux.
+func Landlock(read, write []string) error {
+	return ErrUnsupported
+}
+
+// Seccomp installs a filter on every thread of the process that kills it
+// if it makes a system call other than those the Go runtime needs and
+// syscalls. It is only supported on Linux.
+func Seccomp(syscalls []uintptr) error {
+	return ErrUnsupported
+}
+
+// Apply sandboxes every thread of the process with p. It is only supported
+// on Linux.
+func Apply(p Policy) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,146 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// envHelper names the helper a test runs in a child process, as a sandbox
+// cannot be lifted from the test process once applied.
+const envHelper = "SANDBOX_TEST_HELPER"
+
+// helpers are run in a child process with the arguments after "--".
+var helpers = map[string]func(args []string) error{
+	// landlock may read args[0] and then tries to read each of args[1:]
+	// and to write to args[0]
+	"landlock": func(args []string) error {
+		if err := Landlock(args[:1], nil); err != nil {
+			return err
+		}
+		for _, path := range args[1:] {
+			_, err := os.ReadFile(path)
+			fmt.Printf("read %s: %v\n", filepath.Base(path), err)
+		}
+		err := os.WriteFile(filepath.Join(args[0], "new"), nil, 0o644)
+		fmt.Printf("write: %v\n", err)
+		return nil
+	},
+	// seccomp runs goroutines, sleeps and collects garbage, writing what it
+	// does, then calls getppid or reads standard input as args[0] says,
+	// which it is only allowed to if args[1] is "allow"
+	"seccomp": func(args []string) error {
+		call := map[string]uintptr{"getppid": syscall.SYS_GETPPID, "read": syscall.SYS_READ}[args[0]]
+		allowed := []uintptr{syscall.SYS_WRITE}
+		if args[1] == "allow" {
+			allowed = append(allowed, call)
+		}
+		if err := Seccomp(allowed); err != nil {
+			return err
+		}
+		done := make(chan []byte)
+		for range 10 {
+			go func() {
+				time.Sleep(time.Millisecond)
+				done <- make([]byte, 1<<20)
+			}()
+		}
+		for range 10 {
+			<-done
+		}
+		runtime.GC()
+		fmt.Println("ran")
+		syscall.RawSyscall(call, 0, 0, 0)
+		fmt.Println(args[0])
+		return nil
+	},
+}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(envHelper); name != "" {
+		args := os.Args[len(os.Args)-1:]
+		for i, arg := range os.Args {
+			if arg == "--" {
+				args = os.Args[i+1:]
+			}
+		}
+		if err := helpers[name](args); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runHelper runs the helper name with args in a child process and returns
+// its output and how it exited.
+func runHelper(t *testing.T, name string, args ...string) (string, error) {
+	t.Helper()
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skip("sandboxing is only supported on Linux on amd64 and arm64")
+	}
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), envHelper+"="+name)
+	out, err := cmd.Output()
+	if strings.Contains(string(out), "landlock is not available") {
+		t.Skip(strings.TrimSpace(string(out)))
+	}
+	return string(out), err
+}
+
+func TestLandlock(t *testing.T) {
+	allowed, denied := t.TempDir(), t.TempDir()
+	for _, dir := range []string{allowed, denied} {
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(dir)), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := runHelper(t, "landlock", allowed, filepath.Join(allowed, filepath.Base(allowed)), filepath.Join(denied, filepath.Base(denied)))
+	if err != nil {
+		t.Fatalf("Helper failed: %v\n%s", err, out)
+	}
+	want := fmt.Sprintf("read %s: <nil>\nread %s: open %s: permission denied\nwrite: open %s: permission denied\n",
+		filepath.Base(allowed), filepath.Base(denied), filepath.Join(denied, filepath.Base(denied)), filepath.Join(allowed, "new"))
+	if out != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", out, want)
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	for _, call := range []string{"getppid", "read"} {
+		// A system call outside the allowlist kills the process. Reading is
+		// only allowed on the network poller's descriptors.
+		out, err := runHelper(t, "seccomp", call, "deny")
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGSYS {
+			t.Errorf("Helper exited with %v, want killed by SIGSYS\n%s", err, out)
+		}
+		if out != "ran\n" {
+			t.Errorf("Helper output %q, want it to run until %s", out, call)
+		}
+
+		out, err = runHelper(t, "seccomp", call, "allow")
+		if err != nil || out != "ran\n"+call+"\n" {
+			t.Errorf("Helper allowed %s: got %v\n%s", call, err, out)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	for _, transport := range []string{"stdio", "tcp", "unix", "mmap"} {
+		if _, err := Transport(transport); err != nil {
+			t.Errorf("Transport(%s): %v", transport, err)
+		}
+	}
+	if _, err := Transport("pigeon"); err == nil {
+		t.Errorf("Transport(pigeon) succeeded")
+	}
+}
@@ -0,0 +1,55 @@
+package sandbox
+
+import "syscall"
+
+// auditArch is AUDIT_ARCH_X86_64, which seccomp reports system calls made
+// with the 64-bit ABI under.
+const auditArch = 0xc000003e
+
+// System calls the syscall package has no constants for.
+const (
+	sysSeccomp   = 317
+	sysGetrandom = 318
+)
+
+// runtimeSyscalls are the system calls a Go program makes whatever it does:
+// scheduling and parking goroutines on futexes, sleeping, growing the heap
+// and stacks, starting threads and handling signals. The program can also
+// close descriptors it has.
+var runtimeSyscalls = []uintptr{
+	syscall.SYS_FUTEX,
+	syscall.SYS_NANOSLEEP,
+	syscall.SYS_CLOCK_NANOSLEEP,
+	syscall.SYS_SCHED_YIELD,
+	syscall.SYS_SCHED_GETAFFINITY,
+	syscall.SYS_MMAP,
+	syscall.SYS_MUNMAP,
+	syscall.SYS_MADVISE,
+	syscall.SYS_MPROTECT,
+	syscall.SYS_CLONE,
+	syscall.SYS_RT_SIGACTION,
+	syscall.SYS_RT_SIGPROCMASK,
+	syscall.SYS_RT_SIGRETURN,
+	syscall.SYS_SIGALTSTACK,
+	syscall.SYS_TGKILL,
+	syscall.SYS_GETPID,
+	syscall.SYS_GETTID,
+	syscall.SYS_CLOCK_GETTIME,
+	syscall.SYS_GETTIMEOFDAY,
+	syscall.SYS_RESTART_SYSCALL,
+	syscall.SYS_CLOSE,
+	syscall.SYS_EXIT,
+	syscall.SYS_EXIT_GROUP,
+	sysGetrandom,
+}
+
+// pollerSyscalls are the system calls the network poller makes to wait for
+// timers and descriptors and to wake itself, and that reading and writing
+// descriptors through it takes.
+var pollerSyscalls = []uintptr{
+	syscall.SYS_READ,
+	syscall.SYS_WRITE,
+	syscall.SYS_EPOLL_CTL,
+	syscall.SYS_EPOLL_PWAIT,
+	syscall.SYS_EPOLL_WAIT,
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/protocol"
+)
+
+// skipSandbox skips tb where plugins cannot be sandboxed.
+func skipSandbox(tb testing.TB) {
+	tb.Helper()
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		tb.Skip("sandboxing is only supported on Linux on amd64 and arm64")
+	}
+	if *pluginRace {
+		tb.Skip("plugins built with the race detector use cgo and cannot be sandboxed")
+	}
+}
+
+// TestSandbox serves requests from a sandboxed example plugin over each
+// transport, then has it open a file, which the sandbox kills it for. The
+// mmap plugin is also killed for reading a descriptor of its own, as only
+// the descriptor transports may.
+func TestSandbox(t *testing.T) {
+	skipSandbox(t)
+	for _, tt := range []struct {
+		transport  string
+		maxPayload uint32
+	}{
+		{"stdio", protocol.MaxStreamPayload},
+		{"tcp", protocol.MaxStreamPayload},
+		{"unix", protocol.MaxStreamPayload},
+		{"mmap", protocol.MaxMmapPayload},
+	} {
+		t.Run(tt.transport, func(t *testing.T) {
+			p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Transport: tt.transport, Sandbox: true})
+			testHandshake(t, p, "example-plugin", tt.maxPayload)
+			testCallback(t, p)
+			testStream(t, p)
+			testErrors(t, p)
+			testTimeout(t, p)
+			if resp := p.call(t, "sleep", []byte("1ms")); string(resp) != "1ms" {
+				t.Errorf("sleep: got %q", resp)
+			}
+			p.call(t, "alloc", []byte("100000000"))
+
+			testKilled(t, p, "open", "1")
+		})
+	}
+
+	p := startPlugin(t, "example", host.Launch, host.LaunchOptions{Transport: "mmap", Sandbox: true})
+	p.call(t, "ping", nil)
+	testKilled(t, p, "read", "0")
+
+	// A sandboxed plugin shuts down cleanly
+	p = startPlugin(t, "example", host.Launch, host.LaunchOptions{Transport: "unix", Sandbox: true})
+	testShutdown(t, p)
+}
+
+// testKilled calls method with payload and expects the sandbox to kill the
+// plugin for it.
+func testKilled(t *testing.T, p *plugin, method, payload string) {
+	t.Helper()
+	_, err := p.Call(context.Background(), method, []byte(payload))
+	var exitErr *host.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("%s: got %v, want the plugin killed", method, err)
+	}
+	var waitErr interface{ Sys() any }
+	if !errors.As(exitErr.Err, &waitErr) || waitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGSYS {
+		t.Errorf("%s: got %v, want the plugin killed with SIGSYS", method, err)
+	}
+}
+
+func TestSandboxProfile(t *testing.T) {
+	_, err := host.Launch(context.Background(), pluginBinary(t, "example"), host.LaunchOptions{Sandbox: true, ProfileDir: t.TempDir()})
+	if err == nil {
+		t.Errorf("Launch of a sandboxed plugin that is profiled succeeded")
+	}
+}
+
+// benchmarkSandbox runs benchmarkPingPong with the plugin unsandboxed and
+// sandboxed, to show what seccomp filtering its system calls costs.
+func benchmarkSandbox(b *testing.B, pkg string, start startFunc, opts host.LaunchOptions) {
+	skipSandbox(b)
+	for _, sandboxed := range []bool{false, true} {
+		name := "off"
+		if sandboxed {
+			name = "on"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts.Sandbox = sandboxed
+			p := startPlugin(b, pkg, start, opts)
+			benchmarkPingPong(b, p)
+			p.shutdown(b)
+		})
+	}
+}
@@ -1,137 +1,95 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"testing"
+
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/protocol"
 )
 
+// startStdioPlugin starts the stdio plugin.
+func startStdioPlugin(tb testing.TB) *plugin {
+	return startPlugin(tb, "stdio", host.StartStdio, host.LaunchOptions{})
+}
+
 func BenchmarkStdio(b *testing.B) {
-	// Create temporary directory for plugin binary
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		b.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+	p := startStdioPlugin(b)
+	benchmarkPingPong(b, p)
+	p.shutdown(b)
+}
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "stdio-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./stdio")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		b.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
+func BenchmarkStdioCallback(b *testing.B) {
+	p := startStdioPlugin(b)
+	benchmarkCallback(b, p)
+	p.shutdown(b)
+}
 
-	// Start the plugin process
-	cmd := exec.Command(pluginPath)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		b.Fatalf("Failed to create stdin pipe: %v", err)
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		b.Fatalf("Failed to create stdout pipe: %v", err)
-	}
+func BenchmarkStdioStream(b *testing.B) {
+	p := startStdioPlugin(b)
+	benchmarkStream(b, p)
+	p.shutdown(b)
+}
 
-	if err := cmd.Start(); err != nil {
-		b.Fatalf("Failed to start plugin: %v", err)
-	}
+func BenchmarkStdioRecovery(b *testing.B) {
+	benchmarkRecovery(b, "stdio", host.StartStdio, host.LaunchOptions{})
+}
 
-	// Create scanner for reading responses
-	scanner := bufio.NewScanner(stdout)
-
-	// Reset timer after setup
-	b.ResetTimer()
-
-	// Run benchmark
-	for i := 0; i < b.N; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(stdin, "ping"); err != nil {
-			b.Fatalf("Failed to send ping: %v", err)
-		}
-
-		// Read pong
-		if !scanner.Scan() {
-			b.Fatalf("Failed to read response")
-		}
-		response := scanner.Text()
-		if response != "pong" {
-			b.Fatalf("Unexpected response: %s", response)
-		}
-	}
+func BenchmarkStdioPlacement(b *testing.B) {
+	benchmarkPlacement(b, "stdio", host.StartStdio, host.LaunchOptions{})
+}
 
-	b.StopTimer()
+func BenchmarkStdioSandbox(b *testing.B) {
+	benchmarkSandbox(b, "stdio", host.StartStdio, host.LaunchOptions{})
+}
 
-	// Cleanup: send quit command
-	if _, err := fmt.Fprintln(stdin, "quit"); err != nil {
-		b.Errorf("Failed to send quit command: %v", err)
-	}
+func TestStdioPingPong(t *testing.T) {
+	p := startStdioPlugin(t)
+	testPingPong(t, p)
+	p.shutdown(t)
+}
 
-	// Wait for process to exit
-	if err := cmd.Wait(); err != nil {
-		b.Errorf("Plugin process exited with error: %v", err)
-	}
+func TestStdioHandshake(t *testing.T) {
+	p := startStdioPlugin(t)
+	testHandshake(t, p, "stdio", protocol.MaxStreamPayload)
+	p.shutdown(t)
 }
 
-func TestStdioPingPong(t *testing.T) {
-	// Create temporary directory for plugin binary
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+func TestStdioTimeout(t *testing.T) {
+	p := startStdioPlugin(t)
+	testTimeout(t, p)
+	p.shutdown(t)
+}
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "stdio-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./stdio")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
+func TestStdioCallback(t *testing.T) {
+	p := startStdioPlugin(t)
+	testCallback(t, p)
+	p.shutdown(t)
+}
 
-	// Start the plugin process
-	cmd := exec.Command(pluginPath)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		t.Fatalf("Failed to create stdin pipe: %v", err)
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		t.Fatalf("Failed to create stdout pipe: %v", err)
-	}
+func TestStdioStream(t *testing.T) {
+	p := startStdioPlugin(t)
+	testStream(t, p)
+	p.shutdown(t)
+}
 
-	if err := cmd.Start(); err != nil {
-		t.Fatalf("Failed to start plugin: %v", err)
-	}
+func TestStdioErrors(t *testing.T) {
+	p := startStdioPlugin(t)
+	testErrors(t, p)
+	p.shutdown(t)
+}
 
-	// Create scanner for reading responses
-	scanner := bufio.NewScanner(stdout)
-
-	// Test ping/pong
-	for i := 0; i < 5; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(stdin, "ping"); err != nil {
-			t.Fatalf("Failed to send ping: %v", err)
-		}
-
-		// Read pong
-		if !scanner.Scan() {
-			t.Fatalf("Failed to read response")
-		}
-		response := scanner.Text()
-		if response != "pong" {
-			t.Fatalf("Unexpected response: %s", response)
-		}
-	}
+func TestStdioShutdown(t *testing.T) {
+	p := startStdioPlugin(t)
+	testShutdown(t, p)
+}
 
-	// Test quit command
-	if _, err := fmt.Fprintln(stdin, "quit"); err != nil {
-		t.Errorf("Failed to send quit command: %v", err)
+func TestStdioTerminate(t *testing.T) {
+	for _, busy := range []bool{false, true} {
+		p := startStdioPlugin(t)
+		testTerminate(t, p, busy)
 	}
+}
 
-	// Wait for process to exit
-	if err := cmd.Wait(); err != nil {
-		t.Errorf("Plugin process exited with error: %v", err)
-	}
-}
\ No newline at end of file
+func TestStdioCrash(t *testing.T) {
+	testCrash(t, "stdio", host.StartStdio, host.LaunchOptions{})
+}
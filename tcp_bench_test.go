@@ -2,192 +2,165 @@ package main
 
 import (
 	"bufio"
-	"fmt"
+	"context"
+	"io"
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"testing"
 	"time"
-)
 
-func BenchmarkTCP(b *testing.B) {
-	// Create temporary directory for plugin binary
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		b.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/peer"
+	goplugin "github.com/jackc/goipcbench/plugin"
+	"github.com/jackc/goipcbench/protocol"
+)
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "tcp-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./tcp")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		b.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
+// startTCPPlugin starts the TCP plugin.
+func startTCPPlugin(tb testing.TB) *plugin {
+	return startPlugin(tb, "tcp", host.StartTCP, host.LaunchOptions{})
+}
 
-	// Find available port
-	listener, err := net.Listen("tcp", "localhost:0")
-	if err != nil {
-		b.Fatalf("Failed to find available port: %v", err)
-	}
-	port := listener.Addr().(*net.TCPAddr).Port
-	listener.Close()
+func BenchmarkTCP(b *testing.B) {
+	p := startTCPPlugin(b)
+	benchmarkPingPong(b, p)
+	p.shutdown(b)
+}
 
-	// Start the plugin process with port argument
-	cmd := exec.Command(pluginPath, fmt.Sprintf("%d", port))
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		b.Fatalf("Failed to create stdout pipe: %v", err)
-	}
+func BenchmarkTCPCallback(b *testing.B) {
+	p := startTCPPlugin(b)
+	benchmarkCallback(b, p)
+	p.shutdown(b)
+}
 
-	if err := cmd.Start(); err != nil {
-		b.Fatalf("Failed to start plugin: %v", err)
-	}
+func BenchmarkTCPStream(b *testing.B) {
+	p := startTCPPlugin(b)
+	benchmarkStream(b, p)
+	p.shutdown(b)
+}
 
-	// Wait for plugin to be ready
-	scanner := bufio.NewScanner(stdout)
-	if !scanner.Scan() || scanner.Text() != "ready" {
-		b.Fatalf("Plugin did not signal ready")
-	}
+func BenchmarkTCPRecovery(b *testing.B) {
+	benchmarkRecovery(b, "tcp", host.StartTCP, host.LaunchOptions{})
+}
 
-	// Connect to plugin
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		b.Fatalf("Failed to connect to plugin: %v", err)
-	}
-	defer conn.Close()
+func BenchmarkTCPPlacement(b *testing.B) {
+	benchmarkPlacement(b, "tcp", host.StartTCP, host.LaunchOptions{})
+}
 
-	connScanner := bufio.NewScanner(conn)
+func BenchmarkTCPSandbox(b *testing.B) {
+	benchmarkSandbox(b, "tcp", host.StartTCP, host.LaunchOptions{})
+}
 
-	// Reset timer after setup
-	b.ResetTimer()
+func TestTCPPingPong(t *testing.T) {
+	p := startTCPPlugin(t)
+	testPingPong(t, p)
+	p.shutdown(t)
+}
 
-	// Run benchmark
-	for i := 0; i < b.N; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(conn, "ping"); err != nil {
-			b.Fatalf("Failed to send ping: %v", err)
-		}
+func TestTCPHandshake(t *testing.T) {
+	p := startTCPPlugin(t)
+	testHandshake(t, p, "tcp", protocol.MaxStreamPayload)
+	p.shutdown(t)
+}
 
-		// Read pong
-		if !connScanner.Scan() {
-			b.Fatalf("Failed to read response")
-		}
-		response := connScanner.Text()
-		if response != "pong" {
-			b.Fatalf("Unexpected response: %s", response)
-		}
-	}
+func TestTCPTimeout(t *testing.T) {
+	p := startTCPPlugin(t)
+	testTimeout(t, p)
+	p.shutdown(t)
+}
 
-	b.StopTimer()
+func TestTCPCallback(t *testing.T) {
+	p := startTCPPlugin(t)
+	testCallback(t, p)
+	p.shutdown(t)
+}
 
-	// Cleanup: send quit command
-	if _, err := fmt.Fprintln(conn, "quit"); err != nil {
-		b.Errorf("Failed to send quit command: %v", err)
-	}
+func TestTCPStream(t *testing.T) {
+	p := startTCPPlugin(t)
+	testStream(t, p)
+	p.shutdown(t)
+}
 
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
+func TestTCPErrors(t *testing.T) {
+	p := startTCPPlugin(t)
+	testErrors(t, p)
+	p.shutdown(t)
+}
 
-	select {
-	case err := <-done:
-		if err != nil {
-			b.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		b.Errorf("Plugin process did not exit after quit command")
-	}
+func TestTCPShutdown(t *testing.T) {
+	p := startTCPPlugin(t)
+	testShutdown(t, p)
 }
 
-func TestTCPPingPong(t *testing.T) {
-	// Create temporary directory for plugin binary
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
+func TestTCPTerminate(t *testing.T) {
+	for _, busy := range []bool{false, true} {
+		p := startTCPPlugin(t)
+		testTerminate(t, p, busy)
 	}
-	defer os.RemoveAll(tmpDir)
+}
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "tcp-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./tcp")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
+func TestTCPCrash(t *testing.T) {
+	testCrash(t, "tcp", host.StartTCP, host.LaunchOptions{})
+}
 
-	// Find available port
+// TestTCPToken starts the plugin by hand with a token and checks it drops a
+// connection that presents another before serving one that presents it.
+func TestTCPToken(t *testing.T) {
 	listener, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
-		t.Fatalf("Failed to find available port: %v", err)
+		t.Fatal(err)
 	}
-	port := listener.Addr().(*net.TCPAddr).Port
+	addr := listener.Addr().String()
 	listener.Close()
 
-	// Start the plugin process with port argument
-	cmd := exec.Command(pluginPath, fmt.Sprintf("%d", port))
+	token := peer.NewToken()
+	tokenRead, tokenWrite, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenWrite.Write(token)
+	tokenWrite.Close()
+	_, port, _ := net.SplitHostPort(addr)
+	cmd := exec.Command(pluginBinary(t, "tcp"), port)
+	cmd.ExtraFiles = []*os.File{tokenRead}
+	cmd.Env = append(os.Environ(), goplugin.EnvTokenFD+"=3")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		t.Fatalf("Failed to create stdout pipe: %v", err)
+		t.Fatal(err)
 	}
-
 	if err := cmd.Start(); err != nil {
-		t.Fatalf("Failed to start plugin: %v", err)
+		t.Fatal(err)
 	}
-
-	// Wait for plugin to be ready
-	scanner := bufio.NewScanner(stdout)
-	if !scanner.Scan() || scanner.Text() != "ready" {
-		t.Fatalf("Plugin did not signal ready")
-	}
-
-	// Connect to plugin
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		t.Fatalf("Failed to connect to plugin: %v", err)
+	tokenRead.Close()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "ready\n" {
+		t.Fatalf("Plugin did not signal ready: %q, %v", line, err)
 	}
-	defer conn.Close()
 
-	connScanner := bufio.NewScanner(conn)
-
-	// Test ping/pong
-	for i := 0; i < 5; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(conn, "ping"); err != nil {
-			t.Fatalf("Failed to send ping: %v", err)
-		}
-
-		// Read pong
-		if !connScanner.Scan() {
-			t.Fatalf("Failed to read response")
-		}
-		response := connScanner.Text()
-		if response != "pong" {
-			t.Fatalf("Unexpected response: %s", response)
+	dial := func(send []byte) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
 		}
+		t.Cleanup(func() { conn.Close() })
+		conn.Write(send)
+		conn.SetDeadline(time.Now().Add(startTimeout))
+		return conn
 	}
-
-	// Test quit command
-	if _, err := fmt.Fprintln(conn, "quit"); err != nil {
-		t.Errorf("Failed to send quit command: %v", err)
+	hijacker := dial(peer.NewToken())
+	if _, err := hijacker.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Connection with the wrong token: got %v, want it closed", err)
 	}
 
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			t.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		t.Errorf("Plugin process did not exit after quit command")
+	conn := dial(token)
+	client := host.NewClient(protocol.NewStreamConn(conn, conn), host.ClientOptions{})
+	if _, err := client.Handshake(context.Background(), protocol.NewHello("goipcbench", protocol.MaxStreamPayload)); err != nil {
+		t.Fatalf("Handshake with the right token: %v", err)
 	}
-}
\ No newline at end of file
+	if resp, err := client.Call(context.Background(), "ping", nil); err != nil || string(resp) != "pong" {
+		t.Errorf("ping: got %q, %v", resp, err)
+	}
+}
@@ -2,182 +2,150 @@ package main
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"context"
 	"net"
-	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/jackc/goipcbench/host"
+	"github.com/jackc/goipcbench/protocol"
 )
 
+// startUnixPlugin starts the Unix domain socket plugin.
+func startUnixPlugin(tb testing.TB) *plugin {
+	return startPlugin(tb, "unix", host.StartUnix, host.LaunchOptions{})
+}
+
 func BenchmarkUnix(b *testing.B) {
-	// Create temporary directory for plugin binary and socket
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		b.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+	p := startUnixPlugin(b)
+	benchmarkPingPong(b, p)
+	p.shutdown(b)
+}
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "unix-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./unix")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		b.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
-	}
+func BenchmarkUnixCallback(b *testing.B) {
+	p := startUnixPlugin(b)
+	benchmarkCallback(b, p)
+	p.shutdown(b)
+}
 
-	// Create socket path
-	socketPath := filepath.Join(tmpDir, "plugin.sock")
+func BenchmarkUnixStream(b *testing.B) {
+	p := startUnixPlugin(b)
+	benchmarkStream(b, p)
+	p.shutdown(b)
+}
 
-	// Start the plugin process with socket path argument
-	cmd := exec.Command(pluginPath, socketPath)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		b.Fatalf("Failed to create stdout pipe: %v", err)
-	}
+func BenchmarkUnixRecovery(b *testing.B) {
+	benchmarkRecovery(b, "unix", host.StartUnix, host.LaunchOptions{})
+}
 
-	if err := cmd.Start(); err != nil {
-		b.Fatalf("Failed to start plugin: %v", err)
-	}
+func BenchmarkUnixPlacement(b *testing.B) {
+	benchmarkPlacement(b, "unix", host.StartUnix, host.LaunchOptions{})
+}
 
-	// Wait for plugin to be ready
-	scanner := bufio.NewScanner(stdout)
-	if !scanner.Scan() || scanner.Text() != "ready" {
-		b.Fatalf("Plugin did not signal ready")
-	}
+func BenchmarkUnixSandbox(b *testing.B) {
+	benchmarkSandbox(b, "unix", host.StartUnix, host.LaunchOptions{})
+}
 
-	// Connect to plugin
-	conn, err := net.Dial("unix", socketPath)
-	if err != nil {
-		b.Fatalf("Failed to connect to plugin: %v", err)
-	}
-	defer conn.Close()
+func TestUnixPingPong(t *testing.T) {
+	p := startUnixPlugin(t)
+	testPingPong(t, p)
+	p.shutdown(t)
+}
 
-	connScanner := bufio.NewScanner(conn)
-
-	// Reset timer after setup
-	b.ResetTimer()
-
-	// Run benchmark
-	for i := 0; i < b.N; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(conn, "ping"); err != nil {
-			b.Fatalf("Failed to send ping: %v", err)
-		}
-
-		// Read pong
-		if !connScanner.Scan() {
-			b.Fatalf("Failed to read response")
-		}
-		response := connScanner.Text()
-		if response != "pong" {
-			b.Fatalf("Unexpected response: %s", response)
-		}
-	}
+func TestUnixHandshake(t *testing.T) {
+	p := startUnixPlugin(t)
+	testHandshake(t, p, "unix", protocol.MaxStreamPayload)
+	p.shutdown(t)
+}
 
-	b.StopTimer()
+func TestUnixTimeout(t *testing.T) {
+	p := startUnixPlugin(t)
+	testTimeout(t, p)
+	p.shutdown(t)
+}
 
-	// Cleanup: send quit command
-	if _, err := fmt.Fprintln(conn, "quit"); err != nil {
-		b.Errorf("Failed to send quit command: %v", err)
-	}
+func TestUnixCallback(t *testing.T) {
+	p := startUnixPlugin(t)
+	testCallback(t, p)
+	p.shutdown(t)
+}
 
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			b.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		b.Errorf("Plugin process did not exit after quit command")
-	}
+func TestUnixStream(t *testing.T) {
+	p := startUnixPlugin(t)
+	testStream(t, p)
+	p.shutdown(t)
 }
 
-func TestUnixPingPong(t *testing.T) {
-	// Create temporary directory for plugin binary and socket
-	tmpDir, err := os.MkdirTemp("", "goipcbench-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+func TestUnixErrors(t *testing.T) {
+	p := startUnixPlugin(t)
+	testErrors(t, p)
+	p.shutdown(t)
+}
 
-	// Build the plugin
-	pluginPath := filepath.Join(tmpDir, "unix-plugin")
-	buildCmd := exec.Command("go", "build", "-o", pluginPath, "./unix")
-	if output, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("Failed to build plugin: %v\nOutput: %s", err, output)
+// TestUnixShutdown checks the plugin has removed its socket file by the time
+// it exits.
+func TestUnixShutdown(t *testing.T) {
+	p := startUnixPlugin(t)
+	testShutdown(t, p, p.Cmd.Args[1])
+}
+
+func TestUnixTerminate(t *testing.T) {
+	for _, busy := range []bool{false, true} {
+		p := startUnixPlugin(t)
+		testTerminate(t, p, busy, p.Cmd.Args[1])
 	}
+}
 
-	// Create socket path
-	socketPath := filepath.Join(tmpDir, "plugin.sock")
+func TestUnixCrash(t *testing.T) {
+	testCrash(t, "unix", host.StartUnix, host.LaunchOptions{})
+}
 
-	// Start the plugin process with socket path argument
-	cmd := exec.Command(pluginPath, socketPath)
+// TestUnixPeer starts the Unix plugin from a shell, so that this process is
+// not its parent, and checks the plugin refuses to serve it.
+func TestUnixPeer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the peer of a Unix socket can only be checked on Linux")
+	}
+	socketPath := filepath.Join(t.TempDir(), "plugin.sock")
+	cmd := exec.Command("/bin/sh", "-c", `"$0" "$@" & wait`, pluginBinary(t, "unix"), socketPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		t.Fatalf("Failed to create stdout pipe: %v", err)
+		t.Fatal(err)
 	}
-
 	if err := cmd.Start(); err != nil {
-		t.Fatalf("Failed to start plugin: %v", err)
+		t.Fatal(err)
 	}
-
-	// Wait for plugin to be ready
-	scanner := bufio.NewScanner(stdout)
-	if !scanner.Scan() || scanner.Text() != "ready" {
-		t.Fatalf("Plugin did not signal ready")
+	t.Cleanup(func() {
+		// Kill the shell and the plugin with it
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	})
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "ready\n" {
+		t.Fatalf("Plugin did not signal ready: %q, %v", line, err)
 	}
 
-	// Connect to plugin
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
-		t.Fatalf("Failed to connect to plugin: %v", err)
+		t.Fatal(err)
 	}
 	defer conn.Close()
-
-	connScanner := bufio.NewScanner(conn)
-
-	// Test ping/pong
-	for i := 0; i < 5; i++ {
-		// Send ping
-		if _, err := fmt.Fprintln(conn, "ping"); err != nil {
-			t.Fatalf("Failed to send ping: %v", err)
-		}
-
-		// Read pong
-		if !connScanner.Scan() {
-			t.Fatalf("Failed to read response")
-		}
-		response := connScanner.Text()
-		if response != "pong" {
-			t.Fatalf("Unexpected response: %s", response)
-		}
+	conn.SetDeadline(time.Now().Add(startTimeout))
+	client := host.NewClient(protocol.NewStreamConn(conn, conn), host.ClientOptions{})
+	if hello, err := client.Handshake(context.Background(), protocol.NewHello("goipcbench", protocol.MaxStreamPayload)); err == nil {
+		t.Fatalf("Handshake from a process other than the plugin's parent succeeded with %+v", hello)
 	}
-
-	// Test quit command
-	if _, err := fmt.Fprintln(conn, "quit"); err != nil {
-		t.Errorf("Failed to send quit command: %v", err)
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	cmd.Wait()
+	if !strings.Contains(stderr.String(), "Rejected connection") {
+		t.Errorf("Plugin did not report the rejected connection:\n%s", stderr.String())
 	}
-
-	// Wait for process to exit
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			t.Errorf("Plugin process exited with error: %v", err)
-		}
-	case <-time.After(2 * time.Second):
-		cmd.Process.Kill()
-		t.Errorf("Plugin process did not exit after quit command")
-	}
-}
\ No newline at end of file
+}